@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	bridge "github.com/svalabs/kubermatic-argocd-bridge/pkg"
+)
+
+/**
+ * Runs a single dry-run sync and prints the planned changes.
+ * Like kubectl diff, the exit code is 0 without changes, 1 with changes and 2 if the sync failed.
+ */
+func RunDiff(ctx context.Context, kkpArgoBridge *bridge.KKPArgoBridge) int {
+	changes, err := kkpArgoBridge.Diff(ctx)
+
+	writeErr := bridge.WritePlan(os.Stdout, changes)
+	if writeErr != nil {
+		log.Printf("Failed to write plan: %s\n", writeErr)
+		return 2
+	}
+
+	if err != nil {
+		log.Printf("Sync failed, the plan is incomplete: %s\n", err)
+		return 2
+	}
+
+	if len(changes) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
 	_ "embed"
+	"errors"
 	"flag"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	bridge "github.com/svalabs/kubermatic-argocd-bridge/pkg"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -19,7 +26,22 @@ import (
 //go:embed template/cluster-secret.yaml
 var defaultClusterSecretTemplate string
 
+// Default AppProject template
+//
+//go:embed template/app-project.yaml
+var defaultAppProjectTemplate string
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		os.Exit(RunRender(os.Args[2:]))
+	}
+
+	// diff accepts the same flags as the bridge itself
+	args := os.Args[1:]
+	diff := len(args) > 0 && args[0] == "diff"
+	if diff {
+		args = args[1:]
+	}
 
 	kkpKubeConfigPath := flag.String("kkp-kubeconfig", "", "Provide the path to the KKP KubeConfig")
 	kkpServiceAccount := flag.Bool("kkp-serviceaccount", true, "If the default service account should be used for kkp connection")
@@ -29,28 +51,68 @@ func main() {
 	argoCdNamespace := flag.String("argo-namespace", "argocd", "ArgoCD Namespace")
 	refreshInterval := flag.Duration("refresh-interval", 60*time.Second, "Refresh interval")
 	clusterSecretTemplateFlag := flag.String("cluster-secret-template", "", "Cluster Secret Template file")
+	clusterSecretTemplatesFlag := flag.String("cluster-secret-templates", "", "File with named Cluster Secret Templates, which are matched by seed, project or cluster labels before the Cluster Secret Template")
 	cleanupRemovedClusters := flag.Bool("cleanup-removed-clusters", false, "Cleanup removed clusters")
 	cleanupTimedClusters := flag.Bool("cleanup-timed-clusters", false, "Cleanup clusters from removed/unavailable clusters")
 	clusterTimeoutTime := flag.Duration("cluster-timeout-time", 30*time.Second, "Time before a cluster gets deleted, when cleanup-timed-clusters is enabled ")
 	fetchMachineDeployments := flag.Bool("fetch-machine-deployments", false, "Fetch machine deployments from UserCluster and make them available to the Cluster Secret Template")
+	seedParallelism := flag.Int("seed-parallelism", 5, "How many seeds are synced concurrently")
+	seedTimeout := flag.Duration("seed-timeout", 30*time.Second, "Deadline for fetching the UserClusters of a single seed")
+	httpAddress := flag.String("http-address", ":8080", "Address of the HTTP server exposing /metrics, /healthz and /readyz, empty to disable")
+	livenessSyncIntervals := flag.Int("liveness-sync-intervals", 3, "Liveness fails if no sync finished within this many refresh intervals")
+	serverSideApply := flag.Bool("server-side-apply", false, "Write cluster secrets via server-side apply with the kubermatic-argocd-bridge field manager, keeping fields added by other tools")
+	leaderElection := flag.Bool("leader-elect", false, "Use a Lease based leader election, so multiple replicas can run and only the leader writes to ArgoCD")
+	leaderElectionNamespace := flag.String("leader-elect-namespace", "", "Namespace of the leader election Lease inside the ArgoCD cluster, defaults to the ArgoCD namespace")
+	leaderElectionLeaseName := flag.String("leader-elect-lease-name", "kubermatic-argocd-bridge", "Name of the leader election Lease")
+	leaseDuration := flag.Duration("leader-elect-lease-duration", 15*time.Second, "Duration standby replicas wait before taking over an expired Lease")
+	renewDeadline := flag.Duration("leader-elect-renew-deadline", 10*time.Second, "Duration the leader retries renewing the Lease before giving up")
+	retryPeriod := flag.Duration("leader-elect-retry-period", 2*time.Second, "Duration between leader election attempts")
+	appProjects := flag.Bool("app-projects", false, "Create an ArgoCD AppProject for every KKP project, limited to the UserClusters of the project")
+	appProjectTemplateFlag := flag.String("app-project-template", "", "AppProject Template file")
+	userClusterTokens := flag.Bool("user-cluster-tokens", false, "Replace the admin credentials of UserClusters with rotated tokens of a ServiceAccount created by the bridge inside each UserCluster")
+	userClusterTokenServiceAccount := flag.String("user-cluster-token-service-account", "kubermatic-argocd-bridge", "Name of the ServiceAccount and ClusterRoleBinding inside the kube-system namespace of the UserClusters")
+	userClusterTokenClusterRole := flag.String("user-cluster-token-cluster-role", "", "ClusterRole inside the UserClusters bound to the ServiceAccount, required with -user-cluster-tokens")
+	userClusterTokenExpiration := flag.Duration("user-cluster-token-expiration", 24*time.Hour, "Requested lifetime of the tokens, they are rotated once half of it passed")
+	externalClusters := flag.Bool("external-clusters", false, "Also register the ExternalClusters imported into KKP, like EKS, AKS, GKE or kubeconfig based clusters")
+	clusterSelector := flag.String("cluster-selector", "", "Label selector for KKP clusters and ExternalClusters, only matching clusters are bridged")
+	projectSelector := flag.String("project-selector", "", "Label selector for KKP projects, only clusters of matching projects are bridged")
+	includeSeeds := flag.String("include-seeds", "", "Comma separated seed names, only clusters of these seeds are bridged")
+	excludeSeeds := flag.String("exclude-seeds", "", "Comma separated seed names, whose clusters are not bridged")
+	includeProjects := flag.String("include-projects", "", "Comma separated project IDs, only clusters of these projects are bridged")
+	excludeProjects := flag.String("exclude-projects", "", "Comma separated project IDs, whose clusters are not bridged")
+	requireHealthyClusters := flag.Bool("require-healthy-clusters", false, "Only register clusters which are running, not paused and healthy")
+	requiredClusterHealth := flag.String("required-cluster-health", "apiserver,controller,scheduler,etcd", "Comma separated components of the extendedHealth of clusters, which have to be up with -require-healthy-clusters")
+	kubeconfigSecret := flag.String("kubeconfig-secret", "admin-kubeconfig", "Secret on the seed with the kubeconfig stored for a UserCluster, for example viewer-kubeconfig")
+	kubeconfigSecretNamespace := flag.String("kubeconfig-secret-namespace", "cluster-{id}", "Namespace of the kubeconfig secret, {id} is replaced with the cluster ID")
+	kubeconfigSecretKey := flag.String("kubeconfig-secret-key", "kubeconfig", "Key of the kubeconfig inside the kubeconfig secret")
+	clusterObjectsTemplateFlag := flag.String("cluster-objects-template", "", "Template file with additional Kubernetes objects, which are applied to the ArgoCD cluster for every UserCluster")
+	dryRun := flag.Bool("dry-run", false, "Log the changes planned by every sync instead of writing secrets, AppProjects and cluster objects")
+	appProjectRBAC := flag.Bool("app-project-rbac", false, "Add AppProject roles for the owners, project managers, editors and viewers of the KKP project from its UserProjectBindings and GroupProjectBindings")
 
-	flag.Parse()
-
-	clusterSecretTemplate := defaultClusterSecretTemplate
+	// Exits on invalid flags
+	_ = flag.CommandLine.Parse(args)
 
-	if *clusterSecretTemplateFlag != "" {
-		stat, err := os.Stat(*clusterSecretTemplateFlag)
-
-		if err != nil || stat.IsDir() {
-			log.Fatal("Failed to stat clusterSecretTemplateFlag: ", err)
-		}
+	clusterSecretTemplate, err := ReadTemplate(*clusterSecretTemplateFlag, defaultClusterSecretTemplate)
+	if err != nil {
+		log.Fatal("Failed to read clusterSecretTemplateFlag: ", err)
+	}
 
-		data, err := os.ReadFile(*clusterSecretTemplateFlag)
+	var clusterSecretTemplates []bridge.SecretTemplateConfig
+	if *clusterSecretTemplatesFlag != "" {
+		clusterSecretTemplates, _, err = bridge.LoadSecretTemplateConfigs(*clusterSecretTemplatesFlag)
 		if err != nil {
-			log.Fatal("Failed to read clusterSecretTemplateFlag: ", err)
+			log.Fatal("Failed to read clusterSecretTemplatesFlag: ", err)
 		}
+	}
 
-		clusterSecretTemplate = string(data)
+	appProjectTemplate, err := ReadTemplate(*appProjectTemplateFlag, defaultAppProjectTemplate)
+	if err != nil {
+		log.Fatal("Failed to read appProjectTemplateFlag: ", err)
+	}
+
+	clusterObjectsTemplate, err := ReadTemplate(*clusterObjectsTemplateFlag, "")
+	if err != nil {
+		log.Fatal("Failed to read clusterObjectsTemplateFlag: ", err)
 	}
 
 	kkpKubeConfig, err := GetKubeConfig(*kkpKubeConfigPath, *kkpServiceAccount)
@@ -62,13 +124,140 @@ func main() {
 		log.Fatal("Failed to generate Argo KKP KubeConfig: ", err)
 	}
 
-	kkpArgoBridge, err := bridge.NewBridge(kkpKubeConfig, *kkpClusterName, argoKubeConfig, *argoCdNamespace, *refreshInterval, clusterSecretTemplate, *cleanupRemovedClusters, *cleanupTimedClusters, *clusterTimeoutTime, *fetchMachineDeployments)
+	kkpArgoBridge, err := bridge.NewBridge(kkpKubeConfig, argoKubeConfig, bridge.BridgeOptions{
+		KKPClusterName:          *kkpClusterName,
+		ArgoCDNamespace:         *argoCdNamespace,
+		RefreshInterval:         *refreshInterval,
+		ClusterSecretTemplate:   clusterSecretTemplate,
+		CleanupRemovedClusters:  *cleanupRemovedClusters,
+		CleanupTimedClusters:    *cleanupTimedClusters,
+		ClusterTimeout:          *clusterTimeoutTime,
+		FetchMachineDeployments: *fetchMachineDeployments,
+		SeedParallelism:         *seedParallelism,
+		SeedTimeout:             *seedTimeout,
+		LivenessSyncIntervals:   *livenessSyncIntervals,
+		LeaderElection:          *leaderElection,
+		LeaderElectionNamespace: *leaderElectionNamespace,
+		LeaderElectionLeaseName: *leaderElectionLeaseName,
+		LeaseDuration:           *leaseDuration,
+		RenewDeadline:           *renewDeadline,
+		RetryPeriod:             *retryPeriod,
+		ServerSideApply:         *serverSideApply,
+		AppProjects:             *appProjects,
+		AppProjectTemplate:      appProjectTemplate,
+		AppProjectRBAC:          *appProjectRBAC,
+		ClusterObjectsTemplate:  clusterObjectsTemplate,
+		UserClusterTokens:       *userClusterTokens,
+		ExternalClusters:        *externalClusters,
+		ClusterSelector:         *clusterSelector,
+		ProjectSelector:         *projectSelector,
+		IncludeSeeds:            SplitList(*includeSeeds),
+		ExcludeSeeds:            SplitList(*excludeSeeds),
+		IncludeProjects:         SplitList(*includeProjects),
+		ExcludeProjects:         SplitList(*excludeProjects),
+		RequireHealthyClusters:  *requireHealthyClusters,
+		RequiredClusterHealth:   SplitList(*requiredClusterHealth),
+		KubeconfigSecret: bridge.KubeconfigSecretReference{
+			Name:      *kubeconfigSecret,
+			Namespace: *kubeconfigSecretNamespace,
+			Key:       *kubeconfigSecretKey,
+		},
+		UserClusterTokenServiceAccount: *userClusterTokenServiceAccount,
+		UserClusterTokenClusterRole:    *userClusterTokenClusterRole,
+		UserClusterTokenExpiration:     *userClusterTokenExpiration,
+		DryRun:                         *dryRun || diff,
+		ClusterSecretTemplatePath:      *clusterSecretTemplateFlag,
+		ClusterSecretTemplates:         clusterSecretTemplates,
+		ClusterSecretTemplatesPath:     *clusterSecretTemplatesFlag,
+	})
 
 	if err != nil {
 		log.Fatal("Failed to initiate bridge", err)
 	}
 
-	kkpArgoBridge.Connect()
+	// Cancelled on SIGTERM, so in-flight requests get aborted and the bridge exits cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if diff {
+		code := RunDiff(ctx, kkpArgoBridge)
+		stop()
+		os.Exit(code)
+	}
+
+	if *httpAddress != "" {
+		server := NewHTTPServer(*httpAddress, kkpArgoBridge)
+		go func() {
+			log.Printf("Serving metrics and probes on %s\n", *httpAddress)
+			err := server.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal("Failed to serve HTTP: ", err)
+			}
+		}()
+		defer shutdownHTTPServer(server)
+	}
+
+	kkpArgoBridge.Connect(ctx)
+}
+
+func NewHTTPServer(address string, kkpArgoBridge *bridge.KKPArgoBridge) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", kkpArgoBridge.Healthz)
+	mux.HandleFunc("/readyz", kkpArgoBridge.Readyz)
+
+	return &http.Server{
+		Addr:    address,
+		Handler: mux,
+	}
+}
+
+func shutdownHTTPServer(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("Failed to shut down HTTP server: %s\n", err)
+	}
+}
+
+/**
+ * Reads the template file at path, or returns the default template if no path is provided
+ */
+func ReadTemplate(path string, defaultTemplate string) (string, error) {
+	if path == "" {
+		return defaultTemplate, nil
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if stat.IsDir() {
+		return "", errors.New(path + " is a directory")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+/**
+ * Splits a comma separated flag value, ignoring surrounding spaces and empty entries
+ */
+func SplitList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
 }
 
 func GetKubeConfig(kubeConfigPath string, useServiceAccount bool) (*restclient.Config, error) {
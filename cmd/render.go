@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	bridge "github.com/svalabs/kubermatic-argocd-bridge/pkg"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+/**
+ * Renders the cluster secret template offline and prints the resulting Secret, without connecting to any API server.
+ * Returns the exit code of the render subcommand.
+ */
+func RunRender(args []string) int {
+	flags := flag.NewFlagSet("render", flag.ContinueOnError)
+	templateFlag := flags.String("template", "", "Cluster Secret Template file, the default template is used if empty")
+	templatesFlag := flags.String("templates", "", "File with named Cluster Secret Templates, matched before the Cluster Secret Template")
+	clusterFlag := flags.String("cluster", "", "KKP Cluster manifest (required)")
+	projectFlag := flags.String("project", "", "KKP Project manifest (required)")
+	kubeconfigFlag := flags.String("kubeconfig", "", "Kubeconfig of the UserCluster (required)")
+	seedFlag := flags.String("seed", "", "KKP Seed manifest, provides .SeedSpec and .Datacenter")
+	seedName := flags.String("seed-name", "kubermatic", "Name of the seed, if no seed manifest is provided")
+	kkpClusterName := flags.String("kkp-cluster-name", "", "Identifier of the KKP cluster, available as .KKPClusterName")
+	argoCdNamespace := flags.String("argo-namespace", "argocd", "Namespace of the printed Secret")
+	stringData := flags.Bool("string-data", false, "Print the data as readable stringData instead of base64 encoded data")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+
+	secret, err := renderClusterSecret(*templateFlag, *templatesFlag, *clusterFlag, *projectFlag, *kubeconfigFlag, *seedFlag, *seedName, *kkpClusterName, *argoCdNamespace, *stringData)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to render cluster secret: %s\n", err)
+		return 1
+	}
+
+	_, err = os.Stdout.Write(secret)
+	if err != nil {
+		return 1
+	}
+
+	return 0
+}
+
+func renderClusterSecret(templatePath string, templatesPath string, clusterPath string, projectPath string, kubeconfigPath string, seedPath string, seedName string, kkpClusterName string, namespace string, stringData bool) ([]byte, error) {
+	if clusterPath == "" || projectPath == "" || kubeconfigPath == "" {
+		return nil, errors.New("-cluster, -project and -kubeconfig are required")
+	}
+
+	clusterSecretTemplate, err := ReadTemplate(templatePath, defaultClusterSecretTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	var secretTemplateConfigs []bridge.SecretTemplateConfig
+	if templatesPath != "" {
+		secretTemplateConfigs, _, err = bridge.LoadSecretTemplateConfigs(templatesPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	kubeconfig, err := os.ReadFile(kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	seed := &bridge.KKPSeed{Name: seedName}
+	if seedPath != "" {
+		seedObject, err := readManifest(seedPath)
+		if err != nil {
+			return nil, err
+		}
+		seed, err = bridge.NewOfflineSeed(*seedObject)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	clusterObject, err := readManifest(clusterPath)
+	if err != nil {
+		return nil, err
+	}
+	userCluster, err := bridge.NewOfflineUserCluster(*clusterObject, seed, kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	projectObject, err := readManifest(projectPath)
+	if err != nil {
+		return nil, err
+	}
+	project, err := bridge.NewKKPProject(*projectObject)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without a client the connector can only render
+	argoConnector, err := bridge.NewArgoConnector(nil, namespace, kkpClusterName, clusterSecretTemplate, secretTemplateConfigs, false, nil, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	clusterSecret, err := argoConnector.RenderClusterSecret(*userCluster, *project, kkpClusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := clusterSecret.Secret(namespace)
+	if stringData {
+		secret.Data = nil
+		secret.StringData = clusterSecret.Data
+	}
+
+	return sigsyaml.Marshal(secret)
+}
+
+/**
+ * Reads a single Kubernetes object from a YAML or JSON file
+ */
+func readManifest(path string) (*unstructured.Unstructured, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonData, err := yaml.ToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	object := &unstructured.Unstructured{}
+	err = object.UnmarshalJSON(jsonData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return object, nil
+}
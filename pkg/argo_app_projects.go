@@ -0,0 +1,312 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const PROJECT_ID_LABEL_KEY string = BASE_LABEL + "/project-id"
+
+var appProjectSchema = schema.GroupVersionResource{
+	Group:    "argoproj.io",
+	Version:  "v1alpha1",
+	Resource: "appprojects",
+}
+
+/**
+ * Creates and updates one ArgoCD AppProject per KKP project, limited to the UserClusters of the project
+ */
+type AppProjectReconciler struct {
+	client          dynamic.Interface
+	namespace       string
+	kkpClusterName  string
+	template        *template.Template
+	serverSideApply bool
+	rbac            bool
+	// Collects the changes instead of writing them with -dry-run, nil otherwise
+	plan *ChangePlan
+}
+
+/**
+ * Accessable data during templating of an AppProject
+ */
+type AppProjectTemplateData struct {
+	Project        KKPProject
+	UserClusters   []UserCluster
+	BaseLabel      string
+	KKPClusterName string
+}
+
+func NewAppProjectReconciler(client dynamic.Interface, namespace string, kkpClusterName string, appProjectTemplate string, serverSideApply bool, rbac bool, plan *ChangePlan) (*AppProjectReconciler, error) {
+	templ, err := parseTemplate("appproject", appProjectTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse AppProject template: %w", err)
+	}
+
+	return &AppProjectReconciler{
+		client:          client,
+		namespace:       namespace,
+		kkpClusterName:  kkpClusterName,
+		template:        templ,
+		serverSideApply: serverSideApply,
+		rbac:            rbac,
+		plan:            plan,
+	}, nil
+}
+
+/**
+ * Reconciles the AppProjects of all projects.
+ * If not all seeds are connected, the destinations of existing AppProjects can not be known and only missing AppProjects get created.
+ * The same applies to the incomplete projects, which have clusters whose destinations are unknown.
+ */
+func (reconciler *AppProjectReconciler) Reconcile(ctx context.Context, projects []KKPProject, userClusters []UserCluster, allSeedsConnected bool, incompleteProjects map[string]bool, cleanup bool) error {
+	clustersByProject := map[string][]UserCluster{}
+	for _, userCluster := range userClusters {
+		clustersByProject[userCluster.ProjectID] = append(clustersByProject[userCluster.ProjectID], userCluster)
+	}
+
+	var failures []error
+	desiredNames := map[string]bool{}
+
+	for _, project := range projects {
+		appProject, err := reconciler.render(project, clustersByProject[project.ID])
+		if err == nil {
+			desiredNames[appProject.GetName()] = true
+			err = reconciler.store(ctx, appProject, allSeedsConnected && !incompleteProjects[project.ID])
+		}
+		if err != nil {
+			log.Printf("Failed to reconcile AppProject for project %s: %s\n", project.ID, err)
+			failures = append(failures, fmt.Errorf("project %s: %w", project.ID, err))
+		}
+	}
+
+	if cleanup && len(failures) == 0 {
+		err := reconciler.cleanup(ctx, desiredNames)
+		if err != nil {
+			failures = append(failures, err)
+		}
+	}
+
+	return errors.Join(failures...)
+}
+
+/**
+ * Renders the AppProject template and adds the destinations, labels and KKP project roles managed by the bridge
+ */
+func (reconciler *AppProjectReconciler) render(project KKPProject, userClusters []UserCluster) (*unstructured.Unstructured, error) {
+	buf := &bytes.Buffer{}
+	err := reconciler.template.ExecuteTemplate(buf, "appproject", &AppProjectTemplateData{
+		Project:        project,
+		UserClusters:   userClusters,
+		BaseLabel:      BASE_LABEL,
+		KKPClusterName: reconciler.kkpClusterName,
+	})
+	if err != nil {
+		templateRenderFailures.Inc()
+		return nil, err
+	}
+
+	var rendered map[string]interface{}
+	err = yaml.Unmarshal(buf.Bytes(), &rendered)
+	if err != nil {
+		templateRenderFailures.Inc()
+		return nil, err
+	}
+
+	name, ok := rendered["name"].(string)
+	if !ok || name == "" {
+		return nil, errors.New("rendered AppProject template has no valid name")
+	}
+
+	labels, err := FlattenToStringStringMap(valueOrEmptyMap(rendered["labels"]))
+	if err != nil {
+		return nil, err
+	}
+	labels[MANAGED_LABEL] = "true"
+	labels[PROJECT_ID_LABEL_KEY] = project.ID
+	if reconciler.kkpClusterName != "" {
+		labels[KKP_CLUSTER_LABEL] = reconciler.kkpClusterName
+	}
+
+	annotations, err := FlattenToStringStringMap(valueOrEmptyMap(rendered["annotations"]))
+	if err != nil {
+		return nil, err
+	}
+
+	spec, ok := valueOrEmptyMap(rendered["spec"]).(map[string]interface{})
+	if !ok {
+		return nil, errors.New("rendered AppProject template has no valid spec")
+	}
+
+	destinations, err := appProjectDestinations(userClusters)
+	if err != nil {
+		return nil, err
+	}
+	spec["destinations"] = destinations
+
+	if reconciler.rbac {
+		roles, ok := valueOrEmptyList(spec["roles"]).([]interface{})
+		if !ok {
+			return nil, errors.New("rendered AppProject template has no valid spec.roles")
+		}
+		spec["roles"] = append(roles, appProjectRBACRoles(name, project.Bindings)...)
+	}
+
+	appProject := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": appProjectSchema.GroupVersion().String(),
+		"kind":       "AppProject",
+		"spec":       spec,
+	}}
+	appProject.SetName(name)
+	appProject.SetNamespace(reconciler.namespace)
+	appProject.SetLabels(labels)
+	appProject.SetAnnotations(annotations)
+
+	return appProject, nil
+}
+
+/**
+ * One destination for every API server of the UserClusters, sorted to keep the AppProject stable
+ */
+func appProjectDestinations(userClusters []UserCluster) ([]interface{}, error) {
+	servers := []string{}
+	for _, userCluster := range userClusters {
+		kubeconfig, err := clientcmd.RESTConfigFromKubeConfig(userCluster.kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("invalid kubeconfig of UserCluster %s: %w", userCluster.ID, err)
+		}
+		servers = append(servers, kubeconfig.Host)
+	}
+	sort.Strings(servers)
+
+	destinations := []interface{}{}
+	for _, server := range servers {
+		destinations = append(destinations, map[string]interface{}{
+			"server":    server,
+			"namespace": "*",
+		})
+	}
+
+	return destinations, nil
+}
+
+func (reconciler *AppProjectReconciler) store(ctx context.Context, appProject *unstructured.Unstructured, update bool) error {
+	appProjects := reconciler.client.Resource(appProjectSchema).Namespace(reconciler.namespace)
+
+	existing, err := appProjects.Get(ctx, appProject.GetName(), metav1.GetOptions{})
+	if apiErrors.IsNotFound(err) && reconciler.plan != nil {
+		reconciler.planAppProject(PLAN_CREATE, appProject.GetName())
+		return nil
+	}
+	if apiErrors.IsNotFound(err) {
+		log.Printf("Creating AppProject %s\n", appProject.GetName())
+		_, err = appProjects.Create(ctx, appProject, metav1.CreateOptions{FieldManager: FIELD_MANAGER})
+		return err
+	}
+	if err != nil || !update {
+		return err
+	}
+
+	if reconciler.serverSideApply && reconciler.plan != nil {
+		if !containsFields(existing.Object, appProject.Object) {
+			reconciler.planAppProject(PLAN_UPDATE, appProject.GetName())
+		}
+		return nil
+	}
+	if reconciler.serverSideApply {
+		_, err = appProjects.Apply(ctx, appProject.GetName(), appProject, metav1.ApplyOptions{FieldManager: FIELD_MANAGER, Force: true})
+		return err
+	}
+
+	desired := existing.DeepCopy()
+	desired.Object["spec"] = appProject.Object["spec"]
+	desired.SetLabels(mergeStringMaps(existing.GetLabels(), appProject.GetLabels()))
+	desired.SetAnnotations(mergeStringMaps(existing.GetAnnotations(), appProject.GetAnnotations()))
+
+	if equality.Semantic.DeepEqual(existing.Object, desired.Object) {
+		return nil
+	}
+
+	if reconciler.plan != nil {
+		reconciler.planAppProject(PLAN_UPDATE, appProject.GetName())
+		return nil
+	}
+
+	log.Printf("Updating AppProject %s\n", appProject.GetName())
+	_, err = appProjects.Update(ctx, desired, metav1.UpdateOptions{FieldManager: FIELD_MANAGER})
+	return err
+}
+
+/**
+ * Removes managed AppProjects whose KKP project no longer exists
+ */
+func (reconciler *AppProjectReconciler) cleanup(ctx context.Context, desiredNames map[string]bool) error {
+	labelSelector := MANAGED_LABEL + "=true," + PROJECT_ID_LABEL_KEY
+	if reconciler.kkpClusterName != "" {
+		labelSelector += "," + KKP_CLUSTER_LABEL + "=" + reconciler.kkpClusterName
+	}
+
+	appProjects := reconciler.client.Resource(appProjectSchema).Namespace(reconciler.namespace)
+	list, err := appProjects.List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		return err
+	}
+
+	for _, appProject := range list.Items {
+		if desiredNames[appProject.GetName()] {
+			continue
+		}
+
+		if reconciler.plan != nil {
+			reconciler.planAppProject(PLAN_DELETE, appProject.GetName())
+			continue
+		}
+
+		log.Printf("Deleting AppProject %s of removed project\n", appProject.GetName())
+		err = appProjects.Delete(ctx, appProject.GetName(), metav1.DeleteOptions{})
+		if err != nil && !apiErrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func valueOrEmptyMap(value interface{}) interface{} {
+	if value == nil {
+		return map[string]interface{}{}
+	}
+	return value
+}
+
+func valueOrEmptyList(value interface{}) interface{} {
+	if value == nil {
+		return []interface{}{}
+	}
+	return value
+}
+
+func mergeStringMaps(base map[string]string, overrides map[string]string) map[string]string {
+	merged := map[string]string{}
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,271 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/json"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+const (
+	FIELD_MANAGER string = "kubermatic-argocd-bridge"
+	// Owns the timeout start label, so it can be dropped without touching the fields of FIELD_MANAGER
+	TIMEOUT_FIELD_MANAGER = FIELD_MANAGER + "-timeout"
+)
+
+/**
+ * Stores the Secret via server-side apply, fields of other managers are kept and
+ * keys which are no longer rendered get removed by the API server.
+ */
+func (connector *ArgoConnector) applyClusterSecret(ctx context.Context, secretName string, labels map[string]string, annotations map[string]string, data map[string]string) (StoreResult, error) {
+	secrets := connector.client.CoreV1().Secrets(connector.namespace)
+
+	live, err := secrets.Get(ctx, secretName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		live = nil
+	} else if err != nil {
+		return "", err
+	}
+
+	if connector.plan != nil {
+		return connector.planApply(live, secretName, labels, annotations, withLiveToken(live, data))
+	}
+
+	secretConfig := corev1ac.Secret(secretName, connector.namespace).
+		WithLabels(labels).
+		WithAnnotations(annotations).
+		WithData(TransformStringStringMapValuesToByteArray(data))
+
+	applied, err := secrets.Apply(ctx, secretConfig, metav1.ApplyOptions{FieldManager: FIELD_MANAGER, Force: true})
+	if err != nil {
+		return "", err
+	}
+
+	if _, ok := applied.Labels[TIMEOUT_START_LABEL]; ok {
+		// An empty apply releases all fields of the timeout manager
+		applied, err = secrets.Apply(ctx, corev1ac.Secret(secretName, connector.namespace), metav1.ApplyOptions{FieldManager: TIMEOUT_FIELD_MANAGER, Force: true})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	applied, err = connector.removeUpdateModeKeys(ctx, live, applied, labels, annotations, data)
+	if err != nil {
+		return "", err
+	}
+
+	if live == nil {
+		secretOperations.WithLabelValues(string(STORE_CREATED)).Inc()
+		return STORE_CREATED, nil
+	}
+
+	// The API server does not persist applies without changes
+	if applied.ResourceVersion == live.ResourceVersion {
+		secretUpdatesSkipped.Inc()
+		return STORE_UNCHANGED, nil
+	}
+
+	secretOperations.WithLabelValues(string(STORE_UPDATED)).Inc()
+	return STORE_UPDATED, nil
+}
+
+/**
+ * Secrets written before switching to server-side apply still carry the key tracking annotations and
+ * the keys written by the update mode, which are not owned by FIELD_MANAGER and therefore never removed by an apply.
+ * A timeout start label set by the update mode is not released by the empty apply of TIMEOUT_FIELD_MANAGER either.
+ */
+func (connector *ArgoConnector) removeUpdateModeKeys(ctx context.Context, live *v1.Secret, secret *v1.Secret, labels map[string]string, annotations map[string]string, data map[string]string) (*v1.Secret, error) {
+	staleLabels, staleAnnotations, staleData, err := staleUpdateModeKeys(live, labels, annotations, data)
+	if err != nil {
+		return nil, err
+	}
+
+	removeLabels := map[string]interface{}{}
+	for _, key := range staleLabels {
+		removeLabels[key] = nil
+	}
+	if _, ok := secret.Labels[TIMEOUT_START_LABEL]; ok {
+		removeLabels[TIMEOUT_START_LABEL] = nil
+	}
+
+	removeAnnotations := map[string]interface{}{}
+	for _, key := range append(staleAnnotations, LAST_LABELS_ANNOTATION, LAST_ANNOTATIONS_ANNOTATION) {
+		if _, ok := secret.Annotations[key]; ok {
+			removeAnnotations[key] = nil
+		}
+	}
+
+	removeData := map[string]interface{}{}
+	for _, key := range staleData {
+		removeData[key] = nil
+	}
+
+	if len(removeLabels) == 0 && len(removeAnnotations) == 0 && len(removeData) == 0 {
+		return secret, nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      removeLabels,
+			"annotations": removeAnnotations,
+		},
+		"data": removeData,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return connector.client.CoreV1().Secrets(connector.namespace).Patch(ctx, secret.Name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: FIELD_MANAGER})
+}
+
+/**
+ * Returns the keys the update mode tracked in its annotations and which are no longer rendered.
+ * The update mode replaced the whole data, so every data key which is no longer rendered was written by it.
+ */
+func staleUpdateModeKeys(live *v1.Secret, labels map[string]string, annotations map[string]string, data map[string]string) ([]string, []string, []string, error) {
+	if live == nil {
+		return nil, nil, nil, nil
+	}
+
+	_, trackedLabels := live.Annotations[LAST_LABELS_ANNOTATION]
+	_, trackedAnnotations := live.Annotations[LAST_ANNOTATIONS_ANNOTATION]
+	if !trackedLabels && !trackedAnnotations {
+		return nil, nil, nil, nil
+	}
+
+	staleLabels, err := staleTrackedKeys(live.Annotations[LAST_LABELS_ANNOTATION], labels)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse %s: %w", LAST_LABELS_ANNOTATION, err)
+	}
+
+	staleAnnotations, err := staleTrackedKeys(live.Annotations[LAST_ANNOTATIONS_ANNOTATION], annotations)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse %s: %w", LAST_ANNOTATIONS_ANNOTATION, err)
+	}
+
+	var staleData []string
+	for key := range live.Data {
+		if _, ok := data[key]; !ok {
+			staleData = append(staleData, key)
+		}
+	}
+
+	return staleLabels, staleAnnotations, staleData, nil
+}
+
+func staleTrackedKeys(annotation string, rendered map[string]string) ([]string, error) {
+	if annotation == "" {
+		return nil, nil
+	}
+
+	var oldKeys []string
+	err := json.Unmarshal([]byte(annotation), &oldKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []string
+	for _, oldKey := range oldKeys {
+		if _, ok := rendered[oldKey]; !ok {
+			stale = append(stale, oldKey)
+		}
+	}
+
+	return stale, nil
+}
+
+/**
+ * Marks the cluster secret with the start of its removal timeout
+ */
+func (connector *ArgoConnector) StartClusterTimeout(ctx context.Context, cluster v1.Secret, start time.Time) error {
+	timeoutStart := strconv.FormatInt(start.UnixMilli(), 10)
+
+	if !connector.serverSideApply || connector.plan != nil {
+		cluster.ObjectMeta.Labels[TIMEOUT_START_LABEL] = timeoutStart
+		return connector.UpdateCluster(ctx, cluster)
+	}
+
+	secretConfig := corev1ac.Secret(cluster.Name, connector.namespace).
+		WithLabels(map[string]string{TIMEOUT_START_LABEL: timeoutStart})
+
+	_, err := connector.client.CoreV1().Secrets(connector.namespace).Apply(ctx, secretConfig, metav1.ApplyOptions{FieldManager: TIMEOUT_FIELD_MANAGER, Force: true})
+	if err != nil {
+		return fmt.Errorf("failed to apply timeout start: %w", err)
+	}
+
+	return nil
+}
+
+/**
+ * Records the outcome of an apply with -dry-run, keys applied before and no longer rendered would be removed by the API server
+ */
+func (connector *ArgoConnector) planApply(live *v1.Secret, secretName string, labels map[string]string, annotations map[string]string, data map[string]string) (StoreResult, error) {
+	desired := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        secretName,
+			Namespace:   connector.namespace,
+			Labels:      map[string]string{},
+			Annotations: map[string]string{},
+		},
+		Data: map[string][]byte{},
+	}
+
+	if live != nil {
+		desired = live.DeepCopy()
+		for _, key := range appliedKeys(live, FIELD_MANAGER, "metadata", "labels") {
+			delete(desired.Labels, key)
+		}
+		for _, key := range appliedKeys(live, FIELD_MANAGER, "metadata", "annotations") {
+			delete(desired.Annotations, key)
+		}
+		for _, key := range appliedKeys(live, FIELD_MANAGER, "data") {
+			delete(desired.Data, key)
+		}
+		delete(desired.Labels, TIMEOUT_START_LABEL)
+		delete(desired.Annotations, LAST_LABELS_ANNOTATION)
+		delete(desired.Annotations, LAST_ANNOTATIONS_ANNOTATION)
+
+		staleLabels, staleAnnotations, staleData, err := staleUpdateModeKeys(live, labels, annotations, data)
+		if err != nil {
+			return "", err
+		}
+		for _, key := range staleLabels {
+			delete(desired.Labels, key)
+		}
+		for _, key := range staleAnnotations {
+			delete(desired.Annotations, key)
+		}
+		for _, key := range staleData {
+			delete(desired.Data, key)
+		}
+	}
+
+	// Merged into possibly nil maps of the live secret
+	desired.Labels = mergeStringMaps(desired.Labels, labels)
+	desired.Annotations = mergeStringMaps(desired.Annotations, annotations)
+	if desired.Data == nil {
+		desired.Data = map[string][]byte{}
+	}
+	for key, value := range data {
+		desired.Data[key] = []byte(value)
+	}
+
+	if live == nil {
+		connector.planSecret(PLAN_CREATE, nil, desired)
+		return STORE_CREATED, nil
+	}
+
+	if len(secretDiff(live, desired)) == 0 {
+		return STORE_UNCHANGED, nil
+	}
+
+	connector.planSecret(PLAN_UPDATE, live, desired)
+	return STORE_UPDATED, nil
+}
@@ -0,0 +1,310 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	stdErrors "errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+)
+
+// Annotation on the cluster secret, listing the additional objects rendered for the cluster
+const OBJECTS_ANNOTATION string = BASE_LABEL + "/objects"
+
+/**
+ * Reference to an additional object inside the ArgoCD cluster
+ */
+type ObjectReference struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+func (reference ObjectReference) String() string {
+	return strings.Join([]string{reference.APIVersion, reference.Kind, reference.Namespace, reference.Name}, "/")
+}
+
+/**
+ * Renders the cluster objects template, every non empty YAML document is one object.
+ * Without template no objects are rendered.
+ */
+func (connector *ArgoConnector) RenderClusterObjects(userCluster UserCluster, project KKPProject, kkpClusterName string) ([]*unstructured.Unstructured, error) {
+	if connector.objectsTemplate == nil {
+		return []*unstructured.Unstructured{}, nil
+	}
+
+	data, err := newTemplateData(userCluster, project, kkpClusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	err = connector.objectsTemplate.ExecuteTemplate(buf, "objects", data)
+	if err != nil {
+		templateRenderFailures.Inc()
+		return nil, err
+	}
+
+	objects := []*unstructured.Unstructured{}
+	decoder := yaml.NewYAMLOrJSONDecoder(buf, 4096)
+	for {
+		var object map[string]interface{}
+		err = decoder.Decode(&object)
+		if stdErrors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			templateRenderFailures.Inc()
+			return nil, err
+		}
+		if len(object) == 0 {
+			continue
+		}
+
+		rendered := &unstructured.Unstructured{Object: object}
+		if rendered.GetAPIVersion() == "" || rendered.GetKind() == "" || rendered.GetName() == "" {
+			return nil, fmt.Errorf("rendered object %d has no apiVersion, kind or metadata.name", len(objects)+1)
+		}
+
+		labels := rendered.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[MANAGED_LABEL] = "true"
+		labels[CLUSTER_ID_LABEL] = userCluster.ID
+		if kkpClusterName != "" {
+			labels[KKP_CLUSTER_LABEL] = kkpClusterName
+		}
+		rendered.SetLabels(labels)
+
+		objects = append(objects, rendered)
+	}
+
+	return objects, nil
+}
+
+/**
+ * Applies the additional objects of a cluster and deletes those which are no longer rendered.
+ * Objects in the ArgoCD namespace are owned by the cluster secret, so they are also garbage collected with it.
+ * Once the template is removed, all tracked objects get deleted.
+ */
+func (connector *ArgoConnector) storeClusterObjects(ctx context.Context, secretName string, userCluster UserCluster, project KKPProject, kkpClusterName string) error {
+	objects, err := connector.RenderClusterObjects(userCluster, project, kkpClusterName)
+	if err != nil {
+		return fmt.Errorf("failed to render cluster objects: %w", err)
+	}
+
+	secret, err := connector.client.CoreV1().Secrets(connector.namespace).Get(ctx, secretName, metav1.GetOptions{})
+	if errors.IsNotFound(err) && connector.plan != nil {
+		// Only created by the dry-run plan, so nothing is tracked yet
+		secret = &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: secretName}}
+	} else if err != nil {
+		return err
+	}
+
+	current := []ObjectReference{}
+	for _, object := range objects {
+		resource, err := connector.resourceFor(object.GroupVersionKind(), object.GetNamespace())
+		if err != nil {
+			return err
+		}
+		if object.GetNamespace() == "" && resource.namespaced {
+			object.SetNamespace(connector.namespace)
+		}
+
+		if object.GetNamespace() == connector.namespace {
+			object.SetOwnerReferences([]metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "Secret",
+				Name:       secret.Name,
+				UID:        secret.UID,
+			}})
+		}
+
+		if connector.plan != nil {
+			err = connector.planObject(ctx, resource, object)
+		} else {
+			_, err = resource.client(object.GetNamespace()).Apply(ctx, object.GetName(), object, metav1.ApplyOptions{FieldManager: FIELD_MANAGER, Force: true})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply %s %s: %w", object.GetKind(), object.GetName(), err)
+		}
+
+		current = append(current, ObjectReference{
+			APIVersion: object.GetAPIVersion(),
+			Kind:       object.GetKind(),
+			Namespace:  object.GetNamespace(),
+			Name:       object.GetName(),
+		})
+	}
+
+	previous := trackedObjects(secret.Annotations)
+	removed := []ObjectReference{}
+	for _, reference := range previous {
+		if !slices.Contains(current, reference) {
+			removed = append(removed, reference)
+		}
+	}
+
+	err = connector.removeClusterObjects(ctx, removed)
+	if err != nil {
+		return err
+	}
+
+	if slices.Equal(previous, current) || connector.plan != nil {
+		return nil
+	}
+
+	return connector.trackObjects(ctx, secret.Name, current)
+}
+
+/**
+ * Records the objects of the cluster on its secret, to prune them once they are no longer rendered
+ */
+func (connector *ArgoConnector) trackObjects(ctx context.Context, secretName string, references []ObjectReference) error {
+	var value interface{}
+	if len(references) > 0 {
+		encoded, err := json.Marshal(references)
+		if err != nil {
+			return err
+		}
+		value = string(encoded)
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{OBJECTS_ANNOTATION: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = connector.client.CoreV1().Secrets(connector.namespace).Patch(ctx, secretName, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: FIELD_MANAGER})
+	return err
+}
+
+func trackedObjects(annotations map[string]string) []ObjectReference {
+	references := []ObjectReference{}
+
+	value, ok := annotations[OBJECTS_ANNOTATION]
+	if !ok {
+		return references
+	}
+
+	err := json.Unmarshal([]byte(value), &references)
+	if err != nil {
+		log.Printf("Ignoring invalid %s annotation: %s\n", OBJECTS_ANNOTATION, err)
+		return []ObjectReference{}
+	}
+
+	return references
+}
+
+func (connector *ArgoConnector) removeClusterObjects(ctx context.Context, references []ObjectReference) error {
+	for _, reference := range references {
+		gv, err := schema.ParseGroupVersion(reference.APIVersion)
+		if err != nil {
+			return err
+		}
+
+		resource, err := connector.resourceFor(gv.WithKind(reference.Kind), reference.Namespace)
+		if err != nil {
+			return err
+		}
+
+		if connector.plan != nil {
+			connector.plan.record(PlannedChange{Action: PLAN_DELETE, Kind: reference.Kind, Namespace: reference.Namespace, Name: reference.Name})
+			continue
+		}
+
+		log.Printf("Deleting %s\n", reference)
+		err = resource.client(reference.Namespace).Delete(ctx, reference.Name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete %s: %w", reference, err)
+		}
+	}
+
+	return nil
+}
+
+type mappedResource struct {
+	resource   dynamic.NamespaceableResourceInterface
+	namespaced bool
+}
+
+func (resource mappedResource) client(namespace string) dynamic.ResourceInterface {
+	if resource.namespaced {
+		return resource.resource.Namespace(namespace)
+	}
+	return resource.resource
+}
+
+/**
+ * Maps the kind to its resource, the discovery cache is refreshed once for kinds which are not known yet
+ */
+func (connector *ArgoConnector) resourceFor(gvk schema.GroupVersionKind, namespace string) (mappedResource, error) {
+	if connector.mapper == nil {
+		return mappedResource{}, fmt.Errorf("can not map %s without ArgoCD client", gvk.Kind)
+	}
+
+	mapping, err := connector.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		connector.mapper.Reset()
+		mapping, err = connector.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	if err != nil {
+		return mappedResource{}, err
+	}
+
+	namespaced := mapping.Scope.Name() == meta.RESTScopeNameNamespace
+	if !namespaced && namespace != "" {
+		return mappedResource{}, fmt.Errorf("%s is cluster scoped, but has the namespace %s", gvk.Kind, namespace)
+	}
+
+	return mappedResource{
+		resource:   connector.dynamicClient.Resource(mapping.Resource),
+		namespaced: namespaced,
+	}, nil
+}
+
+/**
+ * Records the apply of an object with -dry-run, objects which already hold all rendered fields are unchanged
+ */
+func (connector *ArgoConnector) planObject(ctx context.Context, resource mappedResource, object *unstructured.Unstructured) error {
+	change := PlannedChange{
+		Action:    PLAN_CREATE,
+		Kind:      object.GetKind(),
+		Namespace: object.GetNamespace(),
+		Name:      object.GetName(),
+	}
+
+	live, err := resource.client(object.GetNamespace()).Get(ctx, object.GetName(), metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		if containsFields(live.Object, object.Object) {
+			return nil
+		}
+		change.Action = PLAN_UPDATE
+	}
+
+	connector.plan.record(change)
+	return nil
+}
@@ -5,16 +5,24 @@ import (
 	"context"
 	"encoding/base64"
 	stdErrors "errors"
+	"fmt"
 	"log"
+	"reflect"
+	"sort"
+	"sync"
 	"text/template"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/json"
 	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/Masterminds/sprig/v3"
@@ -27,40 +35,120 @@ const (
 	CLUSTER_ID_LABEL                   = BASE_LABEL + "/cluster-id"
 	KKP_CLUSTER_LABEL                  = BASE_LABEL + "/kkp-cluster"
 	SEED_LABEL                         = BASE_LABEL + "/seed"
+	EXTERNAL_CLUSTER_LABEL             = BASE_LABEL + "/external-cluster"
 	LAST_LABELS_ANNOTATION             = BASE_LABEL + "/last-labels"
 	LAST_ANNOTATIONS_ANNOTATION        = BASE_LABEL + "/last-annotations"
-	ARGO_CLUSTER_LABEL          string = "argocd.argoproj.io/secret-type=cluster"
+	ARGO_SECRET_TYPE_LABEL      string = "argocd.argoproj.io/secret-type"
+	ARGO_CLUSTER_LABEL          string = ARGO_SECRET_TYPE_LABEL + "=cluster"
+)
+
+/**
+ * Outcome of storing a single cluster secret
+ */
+type StoreResult string
+
+const (
+	STORE_CREATED   StoreResult = "created"
+	STORE_UPDATED   StoreResult = "updated"
+	STORE_UNCHANGED StoreResult = "unchanged"
 )
 
 type ArgoConnector struct {
-	client         *kubernetes.Clientset
-	namespace      string
-	kkpClusterName string
-	secretTemplate *template.Template
+	client          *kubernetes.Clientset
+	namespace       string
+	kkpClusterName  string
+	serverSideApply bool
+	dynamicClient   dynamic.Interface
+	mapper          *restmapper.DeferredDiscoveryRESTMapper
+	// nil if no additional objects are rendered per cluster
+	objectsTemplate *template.Template
+	// Collects the changes instead of writing them with -dry-run, nil otherwise
+	plan *ChangePlan
+
+	// Replaced while running if the template files change
+	templateLock          sync.RWMutex
+	secretTemplates       *secretTemplates
+	secretTemplateText    string
+	secretTemplateConfigs []SecretTemplateConfig
 }
 
-func NewArgoConnector(client *kubernetes.Clientset, namespace string, kkpClusterName string, clusterSecretTemplate string) *ArgoConnector {
-	funcMap := sprig.TxtFuncMap()
-	funcMap["base64"] = base64.StdEncoding.EncodeToString
-	templ, err := template.New("secret").Funcs(funcMap).Parse(clusterSecretTemplate)
+func NewArgoConnector(client *kubernetes.Clientset, namespace string, kkpClusterName string, clusterSecretTemplate string, secretTemplateConfigs []SecretTemplateConfig, serverSideApply bool, dynamicClient dynamic.Interface, clusterObjectsTemplate string, plan *ChangePlan) (*ArgoConnector, error) {
+	templates, err := newSecretTemplates(clusterSecretTemplate, secretTemplateConfigs)
+	if err != nil {
+		return nil, err
+	}
+
+	connector := &ArgoConnector{
+		client:                client,
+		namespace:             namespace,
+		kkpClusterName:        kkpClusterName,
+		secretTemplates:       templates,
+		secretTemplateText:    clusterSecretTemplate,
+		secretTemplateConfigs: secretTemplateConfigs,
+		serverSideApply:       serverSideApply,
+		dynamicClient:         dynamicClient,
+		plan:                  plan,
+	}
+
+	if clusterObjectsTemplate != "" {
+		connector.objectsTemplate, err = parseTemplate("objects", clusterObjectsTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse cluster objects template: %w", err)
+		}
+	}
+
+	// Also needed without template, to remove the objects tracked while a template was configured
+	if client != nil {
+		connector.mapper = restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(client.Discovery()))
+	}
+
+	return connector, nil
+}
+
+/**
+ * Replaces the cluster secret templates, if one of them is invalid all are rejected and the current ones are kept.
+ * Returns false if the templates did not change.
+ */
+func (connector *ArgoConnector) ReloadSecretTemplates(clusterSecretTemplate string, secretTemplateConfigs []SecretTemplateConfig) (bool, error) {
+	connector.templateLock.Lock()
+	defer connector.templateLock.Unlock()
+
+	if clusterSecretTemplate == connector.secretTemplateText && reflect.DeepEqual(secretTemplateConfigs, connector.secretTemplateConfigs) {
+		return false, nil
+	}
+
+	templates, err := newSecretTemplates(clusterSecretTemplate, secretTemplateConfigs)
 	if err != nil {
-		log.Fatal("Failed to parse Secret template", err)
+		return false, err
 	}
-	return &ArgoConnector{client, namespace, kkpClusterName, templ}
+
+	connector.secretTemplates = templates
+	connector.secretTemplateText = clusterSecretTemplate
+	connector.secretTemplateConfigs = secretTemplateConfigs
+	return true, nil
 }
 
-func (connector *ArgoConnector) VerifyNamespace() error {
-	_, err := connector.client.CoreV1().Namespaces().Get(context.TODO(), connector.namespace, metav1.GetOptions{})
+/**
+ * Parses a template with the sprig functions and base64 available
+ */
+func parseTemplate(name string, text string) (*template.Template, error) {
+	funcMap := sprig.TxtFuncMap()
+	funcMap["base64"] = base64.StdEncoding.EncodeToString
+	return template.New(name).Funcs(funcMap).Parse(text)
+}
+
+func (connector *ArgoConnector) VerifyNamespace(ctx context.Context) error {
+	_, err := connector.client.CoreV1().Namespaces().Get(ctx, connector.namespace, metav1.GetOptions{})
 	return err
 }
 
-func (connector *ArgoConnector) CurrentClusters() ([]v1.Secret, error) {
+func (connector *ArgoConnector) CurrentClusters(ctx context.Context) ([]v1.Secret, error) {
 	labelSelector := ARGO_CLUSTER_LABEL + "," + MANAGED_LABEL + "=true"
 	if connector.kkpClusterName != "" {
 		labelSelector += "," + KKP_CLUSTER_LABEL + "=" + connector.kkpClusterName
 	}
 
-	list, err := connector.client.CoreV1().Secrets(connector.namespace).List(context.TODO(), metav1.ListOptions{
+	list, err := connector.client.CoreV1().Secrets(connector.namespace).List(ctx, metav1.ListOptions{
 		LabelSelector: labelSelector,
 	})
 
@@ -71,70 +159,181 @@ func (connector *ArgoConnector) CurrentClusters() ([]v1.Secret, error) {
 }
 
 /**
- * Store the provided clusters inside ArgoCD
+ * Failure to reconcile the secret of a single UserCluster
+ */
+type ClusterError struct {
+	ClusterID string
+	Err       error
+}
+
+func (clusterError *ClusterError) Error() string {
+	return fmt.Sprintf("cluster %s: %s", clusterError.ClusterID, clusterError.Err)
+}
+
+func (clusterError *ClusterError) Unwrap() error {
+	return clusterError.Err
+}
+
+/**
+ * Store the provided clusters inside ArgoCD.
+ * A failing cluster does not stop the others, all failures are returned joined as ClusterErrors.
  */
-func (connector *ArgoConnector) StoreClusters(userClusters []UserCluster, projects []KKPProject) error {
+func (connector *ArgoConnector) StoreClusters(ctx context.Context, userClusters []UserCluster, projects []KKPProject) error {
 	reconciled := 0
+	unchanged := 0
+	var failures []error
 
 	for _, userCluster := range userClusters {
 		var project KKPProject
-		projectID := userCluster.RawData["metadata"].(map[string]interface{})["labels"].(map[string]interface{})["project-id"]
 
 		for _, availableProject := range projects {
-			if availableProject.ID == projectID {
+			if availableProject.ID == userCluster.ProjectID {
 				project = availableProject
 				break
 			}
 		}
 
-		err := connector.StoreClusterI(userCluster, project, connector.kkpClusterName)
+		result, err := connector.StoreClusterI(ctx, userCluster, project, connector.kkpClusterName)
 		if err != nil {
-			return err
+			log.Printf("Failed to reconcile Argo Secret for UserCluster %s: %s\n", userCluster.ID, err)
+			failures = append(failures, &ClusterError{ClusterID: userCluster.ID, Err: err})
+			continue
 		}
 
+		if result == STORE_UNCHANGED {
+			unchanged++
+		}
 		reconciled++
 	}
 
-	log.Printf("Reconciled Argo Secrets for %d UserClusters\n", reconciled)
+	log.Printf("Reconciled Argo Secrets for %d UserClusters, skipped %d unchanged\n", reconciled, unchanged)
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to reconcile %d of %d UserClusters:\n%w", len(failures), len(userClusters), stdErrors.Join(failures...))
+	}
 
 	return nil
 }
 
 /**
- * Builds the desired Secret and stores in inside the cluster
+ * Cluster secret rendered from the template, before it is written to ArgoCD
  */
-func (connector *ArgoConnector) StoreClusterI(userCluster UserCluster, project KKPProject, kkpClusterName string) error {
+type ClusterSecret struct {
+	Name        string
+	Labels      map[string]string
+	Annotations map[string]string
+	Data        map[string]string
+}
 
-	filledTemplateRaw, err := connector.ParseTemplate(userCluster, project, kkpClusterName)
+/**
+ * Returns the Secret manifest, as it gets created inside the namespace
+ */
+func (clusterSecret *ClusterSecret) Secret(namespace string) *v1.Secret {
+	return &v1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        clusterSecret.Name,
+			Namespace:   namespace,
+			Labels:      clusterSecret.Labels,
+			Annotations: clusterSecret.Annotations,
+		},
+		Data: TransformStringStringMapValuesToByteArray(clusterSecret.Data),
+	}
+}
+
+/**
+ * Renders the template and flattens its labels, annotations and data into the desired cluster secret.
+ * The result is validated, so a broken template does not get written to ArgoCD.
+ */
+func (connector *ArgoConnector) RenderClusterSecret(userCluster UserCluster, project KKPProject, kkpClusterName string) (*ClusterSecret, error) {
+	filledTemplateRaw, templateName, err := connector.ParseTemplate(userCluster, project, kkpClusterName)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	filledTemplate := filledTemplateRaw.(map[string]interface{})
+	filledTemplate, ok := filledTemplateRaw.(map[string]interface{})
+	if !ok {
+		return nil, stdErrors.New("rendered template is not a map")
+	}
 
-	secretName := filledTemplate["name"].(string)
+	secretName, ok := filledTemplate["name"].(string)
+	if !ok {
+		return nil, stdErrors.New("rendered template has no valid name")
+	}
 
 	labels, err := FlattenToStringStringMap(filledTemplate["labels"])
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	// Cleanup relies on this label instead of the seed label for ExternalClusters
+	if userCluster.External {
+		labels[EXTERNAL_CLUSTER_LABEL] = "true"
+	}
+	labels[TEMPLATE_LABEL] = templateName
+
 	annotations, err := FlattenToStringStringMap(filledTemplate["annotations"])
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	data, err := FlattenToStringStringMap(filledTemplate["data"])
 
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	clusterSecret := &ClusterSecret{
+		Name:        secretName,
+		Labels:      labels,
+		Annotations: annotations,
+		Data:        data,
+	}
+
+	err = ValidateClusterSecret(clusterSecret, userCluster)
+	if err != nil {
+		return nil, err
+	}
+
+	return clusterSecret, nil
+}
+
+/**
+ * Builds the desired Secret and stores in inside the cluster.
+ * The update is skipped if the existing Secret already matches the desired one.
+ */
+func (connector *ArgoConnector) StoreClusterI(ctx context.Context, userCluster UserCluster, project KKPProject, kkpClusterName string) (StoreResult, error) {
+	clusterSecret, err := connector.RenderClusterSecret(userCluster, project, kkpClusterName)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := connector.storeSecret(ctx, clusterSecret.Name, clusterSecret.Labels, clusterSecret.Annotations, clusterSecret.Data)
+	if err != nil {
+		return result, err
+	}
+
+	return result, connector.storeClusterObjects(ctx, clusterSecret.Name, userCluster, project, kkpClusterName)
+}
+
+/**
+ * Creates or updates the Secret, via server-side apply if enabled
+ */
+func (connector *ArgoConnector) storeSecret(ctx context.Context, secretName string, labels map[string]string, annotations map[string]string, data map[string]string) (StoreResult, error) {
+	if connector.serverSideApply {
+		return connector.applyClusterSecret(ctx, secretName, labels, annotations, data)
 	}
 
-	ctx := context.TODO()
 	secret, err := connector.client.CoreV1().Secrets(connector.namespace).Get(ctx, secretName, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return "", err
+	}
 	if errors.IsNotFound(err) {
 		newSecret := &v1.Secret{
 			ObjectMeta: metav1.ObjectMeta{
@@ -146,10 +345,25 @@ func (connector *ArgoConnector) StoreClusterI(userCluster UserCluster, project K
 			Data: TransformStringStringMapValuesToByteArray(data),
 		}
 
+		if connector.plan != nil {
+			connector.planSecret(PLAN_CREATE, nil, newSecret)
+			return STORE_CREATED, nil
+		}
+
 		_, err := connector.client.CoreV1().Secrets(connector.namespace).Create(ctx, newSecret, metav1.CreateOptions{})
+		if err != nil {
+			return "", err
+		}
 
-		return err
+		secretOperations.WithLabelValues(string(STORE_CREATED)).Inc()
+		return STORE_CREATED, nil
 	} else {
+		if connector.plan != nil {
+			data = withLiveToken(secret, data)
+		}
+
+		live := secret.DeepCopy()
+
 		secret.Data = TransformStringStringMapValuesToByteArray(data)
 
 		if secret.Labels == nil {
@@ -171,16 +385,32 @@ func (connector *ArgoConnector) StoreClusterI(userCluster UserCluster, project K
 
 		err := connector.cleanUpMetadataMap(*secret, labels, secret.Labels, LAST_LABELS_ANNOTATION)
 		if err != nil {
-			return err
+			return "", err
 		}
 		err = connector.cleanUpMetadataMap(*secret, annotations, secret.Annotations, LAST_ANNOTATIONS_ANNOTATION)
 		if err != nil {
-			return err
+			return "", err
+		}
+
+		if equality.Semantic.DeepEqual(live.Labels, secret.Labels) &&
+			equality.Semantic.DeepEqual(live.Annotations, secret.Annotations) &&
+			equality.Semantic.DeepEqual(live.Data, secret.Data) {
+			secretUpdatesSkipped.Inc()
+			return STORE_UNCHANGED, nil
+		}
+
+		if connector.plan != nil {
+			connector.planSecret(PLAN_UPDATE, live, secret)
+			return STORE_UPDATED, nil
 		}
 
 		_, err = connector.client.CoreV1().Secrets(connector.namespace).Update(ctx, secret, metav1.UpdateOptions{})
+		if err != nil {
+			return "", err
+		}
 
-		return err
+		secretOperations.WithLabelValues(string(STORE_UPDATED)).Inc()
+		return STORE_UPDATED, nil
 	}
 }
 
@@ -204,6 +434,8 @@ func (connector *ArgoConnector) cleanUpMetadataMap(secret v1.Secret, newData map
 	for key := range newData {
 		newKeys = append(newKeys, key)
 	}
+	// Stable order, so an unchanged Secret results in the same annotation
+	sort.Strings(newKeys)
 	marshal, err := json.Marshal(newKeys)
 	if err != nil {
 		return err
@@ -224,60 +456,61 @@ type TemplateData struct {
 	Project        KKPProject
 	Labels         map[string]string
 	Annotations    map[string]string
+	// Spec of the seed and the datacenter used by the cluster, both empty for ExternalClusters
+	SeedSpec   KKPSeedSpec
+	Datacenter KKPDatacenter
 }
 
 /**
- * Takes the provided Secret Template and renders it with different supported data
+ * Takes the Secret Template matching the cluster and renders it with different supported data.
+ * Also returns the name of the used template.
  */
-func (contector *ArgoConnector) ParseTemplate(userCluster UserCluster, project KKPProject, kkpClusterName string) (interface{}, error) {
-	kubeconfig, err := clientcmd.RESTConfigFromKubeConfig(userCluster.kubeconfig)
-
+func (contector *ArgoConnector) ParseTemplate(userCluster UserCluster, project KKPProject, kkpClusterName string) (interface{}, string, error) {
+	data, err := newTemplateData(userCluster, project, kkpClusterName)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	labels := map[string]string{}
-	annotations := map[string]string{}
 
-	if project.RawData["metadata"].(map[string]interface{})["labels"] != nil {
-		projectLabels, err := FlattenToStringStringMap(project.RawData["metadata"].(map[string]interface{})["labels"])
-		if err != nil {
-			return nil, err
-		}
+	contector.templateLock.RLock()
+	templateName, secretTemplate := contector.secretTemplates.forCluster(userCluster, project)
+	contector.templateLock.RUnlock()
 
-		for k, v := range projectLabels {
-			labels[k] = v
-		}
+	buf := &bytes.Buffer{}
+	err = secretTemplate.ExecuteTemplate(buf, "secret", data)
+	if err != nil {
+		templateRenderFailures.Inc()
+		return nil, "", fmt.Errorf("template %s: %w", templateName, err)
 	}
 
-	if userCluster.RawData["metadata"].(map[string]interface{})["labels"] != nil {
-		clusterLabels, err := FlattenToStringStringMap(userCluster.RawData["metadata"].(map[string]interface{})["labels"])
-		if err != nil {
-			return nil, err
-		}
+	var config interface{}
 
-		for k, v := range clusterLabels {
-			labels[k] = v
-		}
+	err = yaml.Unmarshal(buf.Bytes(), &config)
+	if err != nil {
+		templateRenderFailures.Inc()
+		return nil, "", fmt.Errorf("template %s: %w", templateName, err)
 	}
 
-	if project.RawData["metadata"].(map[string]interface{})["annotations"] != nil {
-		projectAnnotations, err := FlattenToStringStringMap(project.RawData["metadata"].(map[string]interface{})["annotations"])
-		if err != nil {
-			return nil, err
-		}
+	return config, templateName, nil
+}
 
-		for k, v := range projectAnnotations {
-			annotations[k] = v
-		}
+func newTemplateData(userCluster UserCluster, project KKPProject, kkpClusterName string) (*TemplateData, error) {
+	kubeconfig, err := clientcmd.RESTConfigFromKubeConfig(userCluster.kubeconfig)
+
+	if err != nil {
+		return nil, err
 	}
+	// Cluster metadata takes precedence over the one of its project
+	labels := map[string]string{}
+	annotations := map[string]string{}
 
-	if userCluster.RawData["metadata"].(map[string]interface{})["annotations"] != nil {
-		clusterAnnotations, err := FlattenToStringStringMap(userCluster.RawData["metadata"].(map[string]interface{})["annotations"])
-		if err != nil {
-			return nil, err
+	for _, source := range []map[string]string{project.Labels, userCluster.Labels} {
+		for k, v := range source {
+			labels[k] = v
 		}
+	}
 
-		for k, v := range clusterAnnotations {
+	for _, source := range []map[string]string{project.Annotations, userCluster.Annotations} {
+		for k, v := range source {
 			annotations[k] = v
 		}
 	}
@@ -290,33 +523,47 @@ func (contector *ArgoConnector) ParseTemplate(userCluster UserCluster, project K
 		Project:        project,
 		Labels:         labels,
 		Annotations:    annotations,
+		Datacenter:     userCluster.Datacenter,
 	}
-
-	buf := &bytes.Buffer{}
-	err = contector.secretTemplate.ExecuteTemplate(buf, "secret", data)
-	if err != nil {
-		return nil, err
+	if userCluster.Seed != nil {
+		data.SeedSpec = userCluster.Seed.Spec
 	}
 
-	var config interface{}
+	return data, nil
+}
 
-	err = yaml.Unmarshal(buf.Bytes(), &config)
+/**
+ * Deletes the cluster secret, together with the additional objects rendered for it
+ */
+func (connector *ArgoConnector) RemoveCluster(ctx context.Context, cluster v1.Secret) error {
+	err := connector.removeClusterObjects(ctx, trackedObjects(cluster.Annotations))
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return config, nil
-}
+	if connector.plan != nil {
+		connector.planSecret(PLAN_DELETE, &cluster, nil)
+		return nil
+	}
 
-/**
- *
- */
-func (connector *ArgoConnector) RemoveCluster(cluster v1.Secret) error {
-	return connector.client.CoreV1().Secrets(connector.namespace).Delete(context.TODO(), cluster.ObjectMeta.Name, metav1.DeleteOptions{})
+	err = connector.client.CoreV1().Secrets(connector.namespace).Delete(ctx, cluster.ObjectMeta.Name, metav1.DeleteOptions{})
+	if err == nil {
+		secretOperations.WithLabelValues("deleted").Inc()
+	}
+	return err
 }
 
-func (connector *ArgoConnector) UpdateCluster(cluster v1.Secret) error {
-	_, err := connector.client.CoreV1().Secrets(connector.namespace).Update(context.TODO(), &cluster, metav1.UpdateOptions{})
+func (connector *ArgoConnector) UpdateCluster(ctx context.Context, cluster v1.Secret) error {
+	if connector.plan != nil {
+		live, err := connector.client.CoreV1().Secrets(connector.namespace).Get(ctx, cluster.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		connector.planSecret(PLAN_UPDATE, live, &cluster)
+		return nil
+	}
+
+	_, err := connector.client.CoreV1().Secrets(connector.namespace).Update(ctx, &cluster, metav1.UpdateOptions{})
 	return err
 }
 
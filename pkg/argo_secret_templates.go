@@ -0,0 +1,176 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// Label on the cluster secret with the name of the template it was rendered from
+const TEMPLATE_LABEL string = BASE_LABEL + "/template"
+
+// Name of the -cluster-secret-template, used if no named template matches
+const DEFAULT_TEMPLATE_NAME string = "default"
+
+/**
+ * Named cluster secret template from the -cluster-secret-templates file.
+ * Either Template holds the template itself or File points to it, relative to the directory of the templates file.
+ */
+type SecretTemplateConfig struct {
+	Name     string                    `json:"name"`
+	Match    SecretTemplateMatchConfig `json:"match"`
+	Template string                    `json:"template,omitempty"`
+	File     string                    `json:"file,omitempty"`
+}
+
+/**
+ * All conditions which are set have to match, empty lists and selectors match every cluster
+ */
+type SecretTemplateMatchConfig struct {
+	Seeds           []string `json:"seeds,omitempty"`
+	Projects        []string `json:"projects,omitempty"`
+	ProjectSelector string   `json:"projectSelector,omitempty"`
+	ClusterSelector string   `json:"clusterSelector,omitempty"`
+}
+
+/**
+ * Reads the -cluster-secret-templates file, templates referenced by File are read into Template.
+ * Returns the templates and all files which were read.
+ */
+func LoadSecretTemplateConfigs(path string) ([]SecretTemplateConfig, []string, error) {
+	files := []string{path}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, files, err
+	}
+
+	var configs []SecretTemplateConfig
+	err = yaml.UnmarshalStrict(data, &configs)
+	if err != nil {
+		return nil, files, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	for i, config := range configs {
+		if config.File == "" {
+			continue
+		}
+		if config.Template != "" {
+			return nil, files, fmt.Errorf("template %s has both template and file", config.Name)
+		}
+
+		file := config.File
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(path), file)
+		}
+		files = append(files, file)
+
+		text, err := os.ReadFile(file)
+		if err != nil {
+			return nil, files, fmt.Errorf("failed to read template %s: %w", config.Name, err)
+		}
+		configs[i].Template = string(text)
+	}
+
+	return configs, files, nil
+}
+
+/**
+ * The cluster secret templates in the order they are matched, with the -cluster-secret-template as fallback
+ */
+type secretTemplates struct {
+	named    []namedSecretTemplate
+	fallback *template.Template
+}
+
+type namedSecretTemplate struct {
+	name            string
+	template        *template.Template
+	seeds           []string
+	projects        []string
+	projectSelector labels.Selector
+	clusterSelector labels.Selector
+}
+
+func newSecretTemplates(clusterSecretTemplate string, configs []SecretTemplateConfig) (*secretTemplates, error) {
+	fallback, err := parseTemplate("secret", clusterSecretTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Secret template: %w", err)
+	}
+
+	templates := &secretTemplates{fallback: fallback}
+	names := map[string]bool{DEFAULT_TEMPLATE_NAME: true}
+
+	for _, config := range configs {
+		if messages := validation.IsDNS1123Label(config.Name); len(messages) > 0 {
+			return nil, fmt.Errorf("invalid template name %q: %s", config.Name, messages[0])
+		}
+		if names[config.Name] {
+			return nil, fmt.Errorf("template name %s is used more than once", config.Name)
+		}
+		names[config.Name] = true
+
+		if config.Template == "" {
+			return nil, fmt.Errorf("template %s is empty", config.Name)
+		}
+
+		parsed, err := parseTemplate("secret", config.Template)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse Secret template %s: %w", config.Name, err)
+		}
+
+		projectSelector, err := labels.Parse(config.Match.ProjectSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid project selector of template %s: %w", config.Name, err)
+		}
+
+		clusterSelector, err := labels.Parse(config.Match.ClusterSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cluster selector of template %s: %w", config.Name, err)
+		}
+
+		templates.named = append(templates.named, namedSecretTemplate{
+			name:            config.Name,
+			template:        parsed,
+			seeds:           config.Match.Seeds,
+			projects:        config.Match.Projects,
+			projectSelector: projectSelector,
+			clusterSelector: clusterSelector,
+		})
+	}
+
+	return templates, nil
+}
+
+/**
+ * Returns the first named template matching the cluster, or the fallback
+ */
+func (templates *secretTemplates) forCluster(userCluster UserCluster, project KKPProject) (string, *template.Template) {
+	for _, named := range templates.named {
+		if named.matches(userCluster, project) {
+			return named.name, named.template
+		}
+	}
+
+	return DEFAULT_TEMPLATE_NAME, templates.fallback
+}
+
+/**
+ * ExternalClusters have no seed, so they never match a template limited to seeds
+ */
+func (named *namedSecretTemplate) matches(userCluster UserCluster, project KKPProject) bool {
+	if len(named.seeds) > 0 && (userCluster.Seed == nil || !slices.Contains(named.seeds, userCluster.Seed.Name)) {
+		return false
+	}
+	if len(named.projects) > 0 && !slices.Contains(named.projects, userCluster.ProjectID) {
+		return false
+	}
+
+	return named.projectSelector.Matches(labels.Set(project.Labels)) && named.clusterSelector.Matches(labels.Set(userCluster.Labels))
+}
@@ -0,0 +1,123 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	stdErrors "errors"
+	"fmt"
+	"net/url"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+/**
+ * The config of an ArgoCD cluster secret, as defined by ClusterConfig of the ArgoCD API.
+ * Byte fields are base64 encoded inside the JSON.
+ */
+type ArgoClusterConfig struct {
+	Username           string                  `json:"username,omitempty"`
+	Password           string                  `json:"password,omitempty"`
+	BearerToken        string                  `json:"bearerToken,omitempty"`
+	TLSClientConfig    ArgoTLSClientConfig     `json:"tlsClientConfig,omitempty"`
+	AWSAuthConfig      *ArgoAWSAuthConfig      `json:"awsAuthConfig,omitempty"`
+	ExecProviderConfig *ArgoExecProviderConfig `json:"execProviderConfig,omitempty"`
+	DisableCompression bool                    `json:"disableCompression,omitempty"`
+	ProxyUrl           string                  `json:"proxyUrl,omitempty"`
+}
+
+type ArgoTLSClientConfig struct {
+	Insecure   bool   `json:"insecure"`
+	ServerName string `json:"serverName,omitempty"`
+	CertData   []byte `json:"certData,omitempty"`
+	KeyData    []byte `json:"keyData,omitempty"`
+	CAData     []byte `json:"caData,omitempty"`
+}
+
+type ArgoAWSAuthConfig struct {
+	ClusterName string `json:"clusterName,omitempty"`
+	RoleARN     string `json:"roleARN,omitempty"`
+	Profile     string `json:"profile,omitempty"`
+}
+
+type ArgoExecProviderConfig struct {
+	Command     string            `json:"command,omitempty"`
+	Args        []string          `json:"args,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	APIVersion  string            `json:"apiVersion,omitempty"`
+	InstallHint string            `json:"installHint,omitempty"`
+}
+
+/**
+ * Checks the rendered secret against what ArgoCD and the cleanup of the bridge rely on, all problems are returned joined
+ */
+func ValidateClusterSecret(clusterSecret *ClusterSecret, userCluster UserCluster) error {
+	var problems []error
+
+	for _, message := range validation.IsDNS1123Subdomain(clusterSecret.Name) {
+		problems = append(problems, fmt.Errorf("name %q: %s", clusterSecret.Name, message))
+	}
+
+	requiredLabels := map[string]string{
+		ARGO_SECRET_TYPE_LABEL: "cluster",
+		MANAGED_LABEL:          "true",
+		CLUSTER_ID_LABEL:       userCluster.ID,
+	}
+	if !userCluster.External {
+		requiredLabels[SEED_LABEL] = userCluster.Seed.Name
+	}
+	labels := []string{}
+	for label := range requiredLabels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		value, ok := clusterSecret.Labels[label]
+		if !ok {
+			problems = append(problems, fmt.Errorf("label %s is missing", label))
+		} else if value != requiredLabels[label] {
+			problems = append(problems, fmt.Errorf("label %s must be %q, not %q", label, requiredLabels[label], value))
+		}
+	}
+
+	server := clusterSecret.Data["server"]
+	if server == "" {
+		problems = append(problems, stdErrors.New("data.server is missing"))
+	} else if serverURL, err := url.Parse(server); err != nil || serverURL.Scheme == "" || serverURL.Host == "" {
+		problems = append(problems, fmt.Errorf("data.server %q is no absolute URL", server))
+	}
+
+	if config, ok := clusterSecret.Data["config"]; ok {
+		err := validateClusterConfig(config)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("data.config: %w", err))
+		}
+	}
+
+	if len(problems) > 0 {
+		secretValidationFailures.Inc()
+		return fmt.Errorf("invalid cluster secret %s: %w", clusterSecret.Name, stdErrors.Join(problems...))
+	}
+
+	return nil
+}
+
+/**
+ * Decodes the config strictly, so unknown fields like typos are reported instead of being ignored by ArgoCD
+ */
+func validateClusterConfig(config string) error {
+	decoder := json.NewDecoder(bytes.NewBufferString(config))
+	decoder.DisallowUnknownFields()
+
+	clusterConfig := ArgoClusterConfig{}
+	err := decoder.Decode(&clusterConfig)
+	if err != nil {
+		return err
+	}
+	if decoder.More() {
+		return stdErrors.New("unexpected data after the config object")
+	}
+
+	return nil
+}
@@ -1,39 +1,114 @@
 package pkg
 
 import (
+	"context"
 	"errors"
-	"os"
-	"os/signal"
+	"fmt"
 	"strconv"
-	"syscall"
+	"sync"
 	"time"
 
 	"log"
 
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/util/workqueue"
 )
 
+/**
+ * Settings of the bridge, mostly provided by commandline flags
+ */
+type BridgeOptions struct {
+	KKPClusterName          string
+	ArgoCDNamespace         string
+	RefreshInterval         time.Duration
+	ClusterSecretTemplate   string
+	CleanupRemovedClusters  bool
+	CleanupTimedClusters    bool
+	ClusterTimeout          time.Duration
+	FetchMachineDeployments bool
+	SeedParallelism         int
+	SeedTimeout             time.Duration
+	LivenessSyncIntervals   int
+	LeaderElection          bool
+	LeaderElectionNamespace string
+	LeaderElectionLeaseName string
+	LeaseDuration           time.Duration
+	RenewDeadline           time.Duration
+	RetryPeriod             time.Duration
+	ServerSideApply         bool
+	AppProjects             bool
+	AppProjectTemplate      string
+	AppProjectRBAC          bool
+	// Additional objects rendered per cluster, disabled when empty
+	ClusterObjectsTemplate string
+	UserClusterTokens      bool
+	ExternalClusters       bool
+	ClusterSelector        string
+	ProjectSelector        string
+	IncludeSeeds           []string
+	ExcludeSeeds           []string
+	IncludeProjects        []string
+	ExcludeProjects        []string
+	RequireHealthyClusters bool
+	RequiredClusterHealth  []string
+	KubeconfigSecret       KubeconfigSecretReference
+	// ServiceAccount and ClusterRole inside the UserClusters, used with UserClusterTokens
+	UserClusterTokenServiceAccount string
+	UserClusterTokenClusterRole    string
+	UserClusterTokenExpiration     time.Duration
+	// Log the planned changes of every sync instead of writing them
+	DryRun bool
+	// File of the ClusterSecretTemplate, watched and reloaded on changes if set
+	ClusterSecretTemplatePath string
+	// Named templates matched before the ClusterSecretTemplate, loaded from ClusterSecretTemplatesPath
+	ClusterSecretTemplates     []SecretTemplateConfig
+	ClusterSecretTemplatesPath string
+}
+
 type KKPArgoBridge struct {
-	kkpClusterName          string
-	argoCDNamespace         string
-	argoClient              *kubernetes.Clientset
-	kkpDynamicClient        *dynamic.DynamicClient
-	kkpStaticClient         *kubernetes.Clientset
-	refreshTime             time.Duration
-	clusterSecretTemplate   string
-	cleanupRemovedClusters  bool
-	cleanupTimedClusters    bool
-	clusterTimeout          time.Duration
-	fetchMachineDeployments bool
-}
-
-func NewBridge(kkpKubeConfig *restclient.Config, kkpClusterName string, argoKubeConfig *restclient.Config, argoCdNamespace string, duration time.Duration, clusterSecretTemplate string, cleanupRemovedClusters bool, cleanupTimedClusters bool, clusterTimeout time.Duration, fetchMachineDeployments bool) (*KKPArgoBridge, error) {
+	options          BridgeOptions
+	argoClient       *kubernetes.Clientset
+	argoDynamic      *dynamic.DynamicClient
+	kkpDynamicClient *dynamic.DynamicClient
+	kkpStaticClient  *kubernetes.Clientset
+	queue            workqueue.TypedRateLimitingInterface[string]
+	watcher          *KKPWatcher
+	health           *health
+	appProjects      *AppProjectReconciler
+	tokenIssuer      *UserClusterTokenIssuer
+	filter           *ClusterFilter
+	healthGate       *ClusterHealthGate
+	plan             *ChangePlan
+}
+
+func NewBridge(kkpKubeConfig *restclient.Config, argoKubeConfig *restclient.Config, options BridgeOptions) (*KKPArgoBridge, error) {
 	if kkpKubeConfig == nil {
 		return nil, errors.New("kkpKubeConfig is nil")
 	}
 
+	if options.SeedParallelism < 1 {
+		return nil, errors.New("seed parallelism must be at least 1")
+	}
+
+	if options.SeedTimeout <= 0 {
+		return nil, errors.New("seed timeout must be positive")
+	}
+
+	if options.LivenessSyncIntervals < 1 {
+		return nil, errors.New("liveness sync intervals must be at least 1")
+	}
+
+	if options.KubeconfigSecret.Name == "" || options.KubeconfigSecret.Namespace == "" || options.KubeconfigSecret.Key == "" {
+		return nil, errors.New("kubeconfig secret name, namespace and key must not be empty")
+	}
+
+	if options.AppProjectRBAC && !options.AppProjects {
+		return nil, errors.New("app project RBAC requires app projects to be enabled")
+	}
+
 	if argoKubeConfig == nil {
 		argoKubeConfig = kkpKubeConfig
 		log.Println("No ArgoCD Kubeconfig provided, falling back to one cluster for both")
@@ -45,6 +120,10 @@ func NewBridge(kkpKubeConfig *restclient.Config, kkpClusterName string, argoKube
 	if err != nil {
 		return nil, err
 	}
+	argoDynamic, err := dynamic.NewForConfig(argoKubeConfig)
+	if err != nil {
+		return nil, err
+	}
 	kkpClient, err := dynamic.NewForConfig(kkpKubeConfig)
 	if err != nil {
 		return nil, err
@@ -53,114 +132,451 @@ func NewBridge(kkpKubeConfig *restclient.Config, kkpClusterName string, argoKube
 	if err != nil {
 		return nil, err
 	}
+
+	filter, err := NewClusterFilter(options.ClusterSelector, options.ProjectSelector, options.IncludeSeeds, options.ExcludeSeeds, options.IncludeProjects, options.ExcludeProjects)
+	if err != nil {
+		return nil, err
+	}
+
+	var healthGate *ClusterHealthGate
+	if options.RequireHealthyClusters {
+		healthGate = NewClusterHealthGate(options.RequiredClusterHealth)
+	}
+
+	var plan *ChangePlan
+	if options.DryRun {
+		plan = NewChangePlan()
+	}
+
+	var tokenIssuer *UserClusterTokenIssuer
+	if options.UserClusterTokens {
+		// Issuing tokens creates a ServiceAccount inside every UserCluster, dry-run plans with the tokens of the existing secrets
+		tokenIssuer, err = NewUserClusterTokenIssuer(options.UserClusterTokenServiceAccount, options.UserClusterTokenClusterRole, options.UserClusterTokenExpiration, options.DryRun)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return &KKPArgoBridge{
-		kkpClusterName:          kkpClusterName,
-		argoCDNamespace:         argoCdNamespace,
-		argoClient:              argoClient,
-		kkpDynamicClient:        kkpClient,
-		kkpStaticClient:         kkpStaticClient,
-		refreshTime:             duration,
-		clusterSecretTemplate:   clusterSecretTemplate,
-		cleanupRemovedClusters:  cleanupRemovedClusters,
-		cleanupTimedClusters:    cleanupTimedClusters,
-		clusterTimeout:          clusterTimeout,
-		fetchMachineDeployments: fetchMachineDeployments,
+		options:          options,
+		argoClient:       argoClient,
+		argoDynamic:      argoDynamic,
+		kkpDynamicClient: kkpClient,
+		kkpStaticClient:  kkpStaticClient,
+		health:           newHealth(),
+		tokenIssuer:      tokenIssuer,
+		filter:           filter,
+		healthGate:       healthGate,
+		plan:             plan,
 	}, nil
 }
 
-func (bridge *KKPArgoBridge) Connect() {
+/**
+ * Runs the bridge until the provided context gets cancelled
+ */
+func (bridge *KKPArgoBridge) Connect(ctx context.Context) {
 	log.Println("Creating Bridge")
 
-	kkpConnector := NewKKPConnector(bridge.kkpDynamicClient, bridge.kkpStaticClient, bridge.fetchMachineDeployments)
-	argoConnector := NewArgoConnector(bridge.argoClient, bridge.argoCDNamespace, bridge.kkpClusterName, bridge.clusterSecretTemplate)
+	kkpConnector, argoConnector, err := bridge.connectors(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	bridge.health.verified.Store(true)
+
+	// Without writes every replica can plan on its own, the Lease would be the only object written
+	if bridge.options.LeaderElection && bridge.options.DryRun {
+		log.Println("Dry-run ignores -leader-elect")
+	}
+
+	if bridge.options.LeaderElection && !bridge.options.DryRun {
+		bridge.runWithLeaderElection(ctx, func(leaderCtx context.Context) {
+			bridge.run(leaderCtx, kkpConnector, argoConnector)
+		})
+	} else {
+		bridge.run(ctx, kkpConnector, argoConnector)
+	}
+
+	log.Println("Bridge stopped")
+}
+
+/**
+ * Builds the connectors and verifies that KKP and the ArgoCD namespace exist
+ */
+func (bridge *KKPArgoBridge) connectors(ctx context.Context) (*KKPConnector, *ArgoConnector, error) {
+	kkpConnector := NewKKPConnector(bridge.kkpDynamicClient, bridge.kkpStaticClient, bridge.options.FetchMachineDeployments, bridge.tokenIssuer, bridge.options.ExternalClusters, bridge.filter, bridge.healthGate, bridge.options.KubeconfigSecret)
+	argoConnector, err := NewArgoConnector(bridge.argoClient, bridge.options.ArgoCDNamespace, bridge.options.KKPClusterName, bridge.options.ClusterSecretTemplate, bridge.options.ClusterSecretTemplates, bridge.options.ServerSideApply, bridge.argoDynamic, bridge.options.ClusterObjectsTemplate, bridge.plan)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = kkpConnector.VerifyCRD(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to verify that KKP is installed: %w", err)
+	}
 
-	err := kkpConnector.VerifyCRD()
+	err = argoConnector.VerifyNamespace(ctx)
 	if err != nil {
-		log.Fatalln("Failed to verify that KKP is installed", err)
+		return nil, nil, fmt.Errorf("the provided argocd namespace %s does not exist: %w", argoConnector.namespace, err)
+	}
+
+	if bridge.options.AppProjects {
+		bridge.appProjects, err = NewAppProjectReconciler(bridge.argoDynamic, bridge.options.ArgoCDNamespace, bridge.options.KKPClusterName, bridge.options.AppProjectTemplate, bridge.options.ServerSideApply, bridge.options.AppProjectRBAC, bridge.plan)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create AppProject reconciler: %w", err)
+		}
 	}
 
-	err = argoConnector.VerifyNamespace()
+	return kkpConnector, argoConnector, nil
+}
+
+/**
+ * Runs a single full sync with -dry-run and returns the changes it would have written
+ */
+func (bridge *KKPArgoBridge) Diff(ctx context.Context) ([]PlannedChange, error) {
+	if bridge.plan == nil {
+		return nil, errors.New("diff requires dry-run to be enabled")
+	}
+
+	kkpConnector, argoConnector, err := bridge.connectors(ctx)
 	if err != nil {
-		log.Fatal("The provided argocd namespace does not exist: ", argoConnector.namespace, err)
+		return nil, err
 	}
 
-	shutdown := make(chan os.Signal, 1)
-	signal.Notify(shutdown, os.Interrupt, os.Kill, syscall.SIGINT, syscall.SIGTERM)
+	err = bridge.Sync(ctx, kkpConnector, argoConnector)
+	return bridge.plan.Drain(), err
+}
+
+/**
+ * Logs the changes planned by the last sync with -dry-run
+ */
+func (bridge *KKPArgoBridge) logPlan() {
+	if bridge.plan == nil {
+		return
+	}
+
+	changes := bridge.plan.Drain()
+	if len(changes) == 0 {
+		return
+	}
 
+	log.Println("Dry-run, planned changes:")
+	err := WritePlan(log.Writer(), changes)
+	if err != nil {
+		log.Printf("Failed to write plan: %s\n", err)
+	}
+}
+
+/**
+ * Watches KKP and processes the workqueue until the context gets cancelled
+ */
+func (bridge *KKPArgoBridge) run(ctx context.Context, kkpConnector *KKPConnector, argoConnector *ArgoConnector) {
+	var err error
+
+	bridge.queue = workqueue.NewTypedRateLimitingQueueWithConfig(
+		workqueue.DefaultTypedControllerRateLimiter[string](),
+		workqueue.TypedRateLimitingQueueConfig[string]{Name: "kkp-clusters"},
+	)
+	defer bridge.queue.ShutDown()
+
+	bridge.watcher, err = NewKKPWatcher(kkpConnector, bridge.queue)
+	if err != nil {
+		log.Fatal("Failed to create KKP watcher: ", err)
+	}
+
+	bridge.watcher.Start(ctx.Done())
+	defer bridge.watcher.Stop()
+
+	if bridge.options.ClusterSecretTemplatePath != "" || bridge.options.ClusterSecretTemplatesPath != "" {
+		go bridge.watchClusterSecretTemplates(ctx, argoConnector)
+	}
+
+	// Periodic full sync as a safety net for missed events, this also triggers the initial sync
+	go wait.Until(func() {
+		bridge.queue.Add(FULL_SYNC_KEY)
+	}, bridge.options.RefreshInterval, ctx.Done())
+
+	// Unblock the worker, the item in progress gets aborted through the context
 	go func() {
-		<-shutdown
+		<-ctx.Done()
 		log.Println("Shutting down Bridge")
-		os.Exit(1)
+		bridge.queue.ShutDown()
 	}()
 
-	for {
+	for bridge.processNextItem(ctx, kkpConnector, argoConnector) {
+	}
+
+}
+
+func (bridge *KKPArgoBridge) processNextItem(ctx context.Context, kkpConnector *KKPConnector, argoConnector *ArgoConnector) bool {
+	key, shutdown := bridge.queue.Get()
+	if shutdown || ctx.Err() != nil {
+		return false
+	}
+	defer bridge.queue.Done(key)
+
+	if key == FULL_SYNC_KEY {
 		start := time.Now()
 
-		err := bridge.Sync(kkpConnector, argoConnector)
+		err := bridge.Sync(ctx, kkpConnector, argoConnector)
 		if err != nil {
 			log.Printf("Failed to sync bridge: %s\n", err)
+		} else {
+			recordSuccessfulSync()
 		}
+		bridge.health.syncFinished(err == nil)
+		bridge.logPlan()
 		log.Printf("Sync took %d\n", time.Since(start))
-		if time.Since(start) < bridge.refreshTime {
-			time.Sleep(bridge.refreshTime - time.Since(start))
-		}
+		syncDuration.WithLabelValues("full").Observe(time.Since(start).Seconds())
+
+		// Failed full syncs are retried by the next periodic sync
+		bridge.queue.Forget(key)
+		return true
+	}
+
+	start := time.Now()
+	err := bridge.SyncCluster(ctx, key, argoConnector)
+	syncDuration.WithLabelValues("cluster").Observe(time.Since(start).Seconds())
+	bridge.logPlan()
+	if err != nil {
+		log.Printf("Failed to sync cluster %s: %s\n", key, err)
+		bridge.queue.AddRateLimited(key)
+		return true
 	}
 
+	bridge.queue.Forget(key)
+	return true
 }
 
-func (bridge *KKPArgoBridge) Sync(kkpConnector *KKPConnector, argoConnector *ArgoConnector) error {
+func (bridge *KKPArgoBridge) Sync(ctx context.Context, kkpConnector *KKPConnector, argoConnector *ArgoConnector) error {
 	log.Println("Syncing Clusters")
 
-	projects, err := kkpConnector.GetProjects()
+	projects, allProjectsParsed, err := kkpConnector.GetProjects(ctx)
 	if err != nil {
 		return err
 	}
 
-	seeds, err := kkpConnector.GetSeeds()
+	seeds, allSeedsLoaded, err := kkpConnector.GetSeeds(ctx)
 
 	if err != nil {
 		return err
 	}
 
-	allUserClusters := []UserCluster{}
+	if bridge.watcher != nil {
+		bridge.watcher.WatchSeeds(seeds)
+	}
 
-	connectedSeeds := []KKPSeed{}
+	connectedSeeds, allUserClusters := bridge.fetchUserClusters(ctx, seeds, projects)
+
+	log.Printf("Got %d UserClusters\n", len(allUserClusters))
+
+	externalClustersListed := false
+	if bridge.options.ExternalClusters {
+		externalClusters, err := kkpConnector.GetExternalClusters(ctx, projects)
+		if err != nil {
+			log.Printf("Failed to get ExternalClusters: %s\n", err)
+		} else {
+			log.Printf("Got %d ExternalClusters\n", len(externalClusters))
+			allUserClusters = append(allUserClusters, externalClusters...)
+			externalClustersListed = true
+		}
+	}
+
+	readyClusters, presentClusters, deletingClusterIDs := bridge.partitionByHealth(allUserClusters)
+
+	// Failed clusters are still part of allUserClusters, so cleanup keeps their existing secrets
+	storeErr := argoConnector.StoreClusters(ctx, readyClusters, projects)
+
+	var deleteErr error
+	if len(deletingClusterIDs) > 0 {
+		deleteErr = bridge.removeClusterSecrets(ctx, argoConnector, deletingClusterIDs, true)
+	}
+
+	// Clusters which are not ready are still present, so their existing secrets are kept
+	err = bridge.CleanupClusters(ctx, argoConnector, presentClusters, connectedSeeds, externalClustersListed)
+
+	var appProjectErr error
+	if bridge.appProjects != nil {
+		allSourcesListed := allSeedsLoaded && len(connectedSeeds) == len(seeds) && (externalClustersListed || !bridge.options.ExternalClusters)
+
+		// Without kubeconfig the destinations of clusters which are not ready are unknown, so the AppProjects of their projects are kept
+		incompleteProjects := map[string]bool{}
+		for _, userCluster := range presentClusters {
+			if !userCluster.Health.Ready {
+				incompleteProjects[userCluster.ProjectID] = true
+			}
+		}
+
+		appProjectErr = bridge.reconcileAppProjects(ctx, kkpConnector, projects, allProjectsParsed, readyClusters, allSourcesListed, incompleteProjects)
+	}
+
+	return errors.Join(storeErr, deleteErr, err, appProjectErr)
+}
+
+/**
+ * Splits the clusters into ready ones, which get stored, present ones, whose secrets are kept, and the IDs of clusters being deleted.
+ * Clusters being deleted are removed regardless of -require-healthy-clusters, without it all other clusters are ready.
+ */
+func (bridge *KKPArgoBridge) partitionByHealth(userClusters []UserCluster) (ready []UserCluster, present []UserCluster, deletingIDs map[string]bool) {
+	deletingIDs = map[string]bool{}
+
+	for _, userCluster := range userClusters {
+		if userCluster.Health.Deleting {
+			deletingIDs[userCluster.ID] = true
+			continue
+		}
+
+		present = append(present, userCluster)
+		if userCluster.Health.Ready {
+			ready = append(ready, userCluster)
+		}
+	}
+
+	return ready, present, deletingIDs
+}
+
+/**
+ * Removes the secrets of the provided cluster IDs, regardless of the cleanup settings
+ */
+func (bridge *KKPArgoBridge) removeClusterSecrets(ctx context.Context, argoConnector *ArgoConnector, clusterIDs map[string]bool, includeExternal bool) error {
+	clusters, err := argoConnector.CurrentClusters(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, existingCluster := range clusters {
+		if !includeExternal && existingCluster.ObjectMeta.Labels[EXTERNAL_CLUSTER_LABEL] == "true" {
+			continue
+		}
+		if clusterIDs[existingCluster.ObjectMeta.Labels[CLUSTER_ID_LABEL]] {
+			log.Printf("Deleting removed cluster %s\n", existingCluster.ObjectMeta.Name)
+			err = argoConnector.RemoveCluster(ctx, existingCluster)
+			if err != nil {
+				return err
+			}
+		}
+	}
 
-	for _, seed := range seeds {
+	return nil
+}
 
-		userClusters, err := seed.GetUserClusters()
+/**
+ * Reconciles the AppProjects, with the project bindings attached if -app-project-rbac is set.
+ * Without bindings the generated roles would be dropped, so AppProjects are left as they are if the bindings can not be read.
+ * AppProjects of projects which could not be parsed would be removed as well, so cleanup requires all projects to be parsed.
+ */
+func (bridge *KKPArgoBridge) reconcileAppProjects(ctx context.Context, kkpConnector *KKPConnector, projects []KKPProject, allProjectsParsed bool, userClusters []UserCluster, allSeedsConnected bool, incompleteProjects map[string]bool) error {
+	if bridge.options.AppProjectRBAC {
+		bindings, err := kkpConnector.GetProjectBindings(ctx)
 		if err != nil {
-			log.Printf("Failed to get user clusters for seed %s: %s\n", seed.Name, err)
+			return fmt.Errorf("failed to get project bindings: %w", err)
+		}
+
+		for i := range projects {
+			projects[i].Bindings = bindings[projects[i].ID]
+		}
+	}
+
+	return bridge.appProjects.Reconcile(ctx, projects, userClusters, allSeedsConnected, incompleteProjects, bridge.options.CleanupRemovedClusters && allProjectsParsed)
+}
+
+/**
+ * Fetches the UserClusters of all seeds concurrently, limited by -seed-parallelism and -seed-timeout per seed.
+ * Seeds which failed are left out of the returned connected seeds.
+ */
+func (bridge *KKPArgoBridge) fetchUserClusters(ctx context.Context, seeds []KKPSeed, projects []KKPProject) ([]KKPSeed, []UserCluster) {
+	results := make([][]UserCluster, len(seeds))
+	failed := make([]bool, len(seeds))
+	slots := make(chan struct{}, bridge.options.SeedParallelism)
+
+	var wg sync.WaitGroup
+	for i, seed := range seeds {
+		wg.Go(func() {
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			seedCtx, cancel := context.WithTimeout(ctx, bridge.options.SeedTimeout)
+			defer cancel()
+
+			start := time.Now()
+			userClusters, err := seed.GetUserClusters(seedCtx, projects)
+			seedFetchDuration.WithLabelValues(seed.Name).Observe(time.Since(start).Seconds())
+			if err != nil {
+				log.Printf("Failed to get user clusters for seed %s: %s\n", seed.Name, err)
+				seedReachable.WithLabelValues(seed.Name).Set(0)
+				seedUserClusters.DeleteLabelValues(seed.Name)
+				failed[i] = true
+				return
+			}
+			seedReachable.WithLabelValues(seed.Name).Set(1)
+			seedUserClusters.WithLabelValues(seed.Name).Set(float64(len(userClusters)))
+			results[i] = userClusters
+		})
+	}
+	wg.Wait()
+
+	allUserClusters := []UserCluster{}
+	connectedSeeds := []KKPSeed{}
+
+	for i, seed := range seeds {
+		if failed[i] {
 			continue
 		}
 
 		connectedSeeds = append(connectedSeeds, seed)
-		allUserClusters = append(allUserClusters, userClusters...)
+		allUserClusters = append(allUserClusters, results[i]...)
+	}
+
+	return connectedSeeds, allUserClusters
+}
 
+/**
+ * Reconciles a single cluster from the watcher caches, after it has been changed on its seed
+ */
+func (bridge *KKPArgoBridge) SyncCluster(ctx context.Context, clusterID string, argoConnector *ArgoConnector) error {
+	seed, cluster, synced := bridge.watcher.GetCluster(clusterID)
+	projects := bridge.watcher.Projects()
+
+	// Clusters moved into an excluded project are handled like removed ones
+	if cluster != nil && !bridge.filter.ClusterAllowed(cluster.GetLabels()[PROJECT_ID_LABEL], projects) {
+		cluster = nil
 	}
 
-	log.Printf("Got %d UserClusters\n", len(allUserClusters))
+	if cluster == nil {
+		if !synced || !bridge.options.CleanupRemovedClusters {
+			return nil
+		}
+
+		// ExternalClusters are not watched per cluster and only removed by full syncs
+		return bridge.removeClusterSecrets(ctx, argoConnector, map[string]bool{clusterID: true}, false)
+	}
 
-	err = argoConnector.StoreClusters(allUserClusters, projects)
+	userCluster, err := seed.GetUserCluster(ctx, *cluster)
 	if err != nil {
 		return err
 	}
 
-	err = bridge.CleanupClusters(argoConnector, allUserClusters, connectedSeeds)
+	readyClusters, _, deletingClusterIDs := bridge.partitionByHealth([]UserCluster{*userCluster})
+	if len(deletingClusterIDs) > 0 {
+		return bridge.removeClusterSecrets(ctx, argoConnector, deletingClusterIDs, false)
+	}
+	if len(readyClusters) == 0 {
+		return nil
+	}
 
-	return err
+	return argoConnector.StoreClusters(ctx, readyClusters, projects)
 }
 
 /**
  * If -cleanup-removed-clusters is set to true, removes cluster which are no longer held by their seed and the seed is still available
  * If -cleanup-timed-clusters is set to true, removes cluster whos seed does no longer exists or is unreachable, after -cluster-timeout-time (default 30 seconds)
+ * ExternalClusters are treated like clusters of a seed, which is available if the ExternalClusters could be listed
  */
-func (bridge *KKPArgoBridge) CleanupClusters(argoConnector *ArgoConnector, userClusters []UserCluster, seeds []KKPSeed) error {
+func (bridge *KKPArgoBridge) CleanupClusters(ctx context.Context, argoConnector *ArgoConnector, userClusters []UserCluster, seeds []KKPSeed, externalClustersListed bool) error {
 
-	if bridge.cleanupRemovedClusters == false && bridge.cleanupTimedClusters == false {
+	if bridge.options.CleanupRemovedClusters == false && bridge.options.CleanupTimedClusters == false {
 		return nil
 	}
-	clusters, err := argoConnector.CurrentClusters()
+	clusters, err := argoConnector.CurrentClusters(ctx)
 	if err != nil {
 		return err
 	}
@@ -172,9 +588,10 @@ clusters:
 			log.Printf("Invalid existing Cluster Secret(missing %s label) for Cluster %s\n", CLUSTER_ID_LABEL, existingCluster.ObjectMeta.Name)
 			continue
 		}
+		external := existingCluster.ObjectMeta.Labels[EXTERNAL_CLUSTER_LABEL] == "true"
 		seedName := existingCluster.ObjectMeta.Labels[SEED_LABEL]
 
-		if len(seedName) == 0 {
+		if !external && len(seedName) == 0 {
 			log.Printf("Invalid existing Cluster Secret(missing %s label) for Cluster %s\n", SEED_LABEL, existingCluster.ObjectMeta.Name)
 			continue
 		}
@@ -185,24 +602,28 @@ clusters:
 			}
 		}
 
+		sourceAvailable := external && externalClustersListed
 		for _, seed := range seeds {
-			if seed.Name == seedName {
-				if bridge.cleanupRemovedClusters {
-					log.Printf("Deleting removed cluster %s\n", existingCluster.ObjectMeta.Name)
-					err = argoConnector.RemoveCluster(existingCluster)
-					if err != nil {
-						log.Printf("Failed to remove cluster %s: %s\n", existingCluster.ObjectMeta.Name, err)
-					}
+			if !external && seed.Name == seedName {
+				sourceAvailable = true
+			}
+		}
+
+		if sourceAvailable {
+			if bridge.options.CleanupRemovedClusters {
+				log.Printf("Deleting removed cluster %s\n", existingCluster.ObjectMeta.Name)
+				err = argoConnector.RemoveCluster(ctx, existingCluster)
+				if err != nil {
+					log.Printf("Failed to remove cluster %s: %s\n", existingCluster.ObjectMeta.Name, err)
 				}
-				continue clusters
 			}
+			continue clusters
 		}
 
-		if bridge.cleanupTimedClusters {
+		if bridge.options.CleanupTimedClusters {
 			timeoutStart := existingCluster.ObjectMeta.Labels[TIMEOUT_START_LABEL]
 			if len(timeoutStart) == 0 {
-				existingCluster.ObjectMeta.Labels[TIMEOUT_START_LABEL] = strconv.FormatInt(time.Now().UnixMilli(), 10)
-				err = argoConnector.UpdateCluster(existingCluster)
+				err = argoConnector.StartClusterTimeout(ctx, existingCluster, time.Now())
 				if err != nil {
 					log.Printf("Failed to add timeout start to %s: %s\n", existingCluster.ObjectMeta.Name, err)
 					continue clusters
@@ -213,9 +634,9 @@ clusters:
 					log.Printf("Failed to parse timeout start (%s) %s\n", TIMEOUT_START_LABEL, timeoutStart)
 					continue clusters
 				}
-				if time.Since(time.UnixMilli(startMillis)) > bridge.clusterTimeout {
+				if time.Since(time.UnixMilli(startMillis)) > bridge.options.ClusterTimeout {
 					log.Printf("Cleaning up expired cluster %s\n", existingCluster.ObjectMeta.Name)
-					err = argoConnector.RemoveCluster(existingCluster)
+					err = argoConnector.RemoveCluster(ctx, existingCluster)
 					if err != nil {
 						log.Printf("Failed to remove cluster %s: %s\n", existingCluster.ObjectMeta.Name, err)
 					}
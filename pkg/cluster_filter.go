@@ -0,0 +1,98 @@
+package pkg
+
+import (
+	"fmt"
+	"slices"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+/**
+ * Limits which seeds, projects and clusters are bridged into ArgoCD.
+ * Empty include lists allow everything, exclude lists always take precedence.
+ */
+type ClusterFilter struct {
+	clusterSelector labels.Selector
+	projectSelector labels.Selector
+	includeSeeds    []string
+	excludeSeeds    []string
+	includeProjects []string
+	excludeProjects []string
+}
+
+func NewClusterFilter(clusterSelector string, projectSelector string, includeSeeds []string, excludeSeeds []string, includeProjects []string, excludeProjects []string) (*ClusterFilter, error) {
+	parsedClusterSelector, err := labels.Parse(clusterSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cluster selector: %w", err)
+	}
+
+	parsedProjectSelector, err := labels.Parse(projectSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid project selector: %w", err)
+	}
+
+	return &ClusterFilter{
+		clusterSelector: parsedClusterSelector,
+		projectSelector: parsedProjectSelector,
+		includeSeeds:    includeSeeds,
+		excludeSeeds:    excludeSeeds,
+		includeProjects: includeProjects,
+		excludeProjects: excludeProjects,
+	}, nil
+}
+
+/**
+ * List options which only return matching clusters
+ */
+func (filter *ClusterFilter) clusterListOptions() metav1.ListOptions {
+	return metav1.ListOptions{LabelSelector: filter.clusterSelector.String()}
+}
+
+/**
+ * List options which only return matching projects
+ */
+func (filter *ClusterFilter) projectListOptions() metav1.ListOptions {
+	return metav1.ListOptions{LabelSelector: filter.projectSelector.String()}
+}
+
+func (filter *ClusterFilter) SeedAllowed(name string) bool {
+	return allowedByLists(name, filter.includeSeeds, filter.excludeSeeds)
+}
+
+func (filter *ClusterFilter) ProjectAllowed(project KKPProject) bool {
+	return filter.projectSelector.Matches(labels.Set(project.Labels)) &&
+		allowedByLists(project.ID, filter.includeProjects, filter.excludeProjects)
+}
+
+/**
+ * Whether projects are filtered at all, otherwise clusters of unknown projects are kept
+ */
+func (filter *ClusterFilter) filtersProjects() bool {
+	return !filter.projectSelector.Empty() || len(filter.includeProjects) > 0 || len(filter.excludeProjects) > 0
+}
+
+/**
+ * Whether a cluster of the project is bridged, the projects have to be filtered already.
+ * Checked before loading the cluster, so excluded clusters are never accessed.
+ */
+func (filter *ClusterFilter) ClusterAllowed(projectID string, projects []KKPProject) bool {
+	if !filter.filtersProjects() {
+		return true
+	}
+
+	for _, project := range projects {
+		if project.ID == projectID {
+			return true
+		}
+	}
+
+	return false
+}
+
+func allowedByLists(value string, include []string, exclude []string) bool {
+	if slices.Contains(exclude, value) {
+		return false
+	}
+	return len(include) == 0 || slices.Contains(include, value)
+}
@@ -0,0 +1,291 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/json"
+)
+
+/**
+ * Kind of write a dry-run skipped
+ */
+type PlannedAction string
+
+const (
+	PLAN_CREATE PlannedAction = "create"
+	PLAN_UPDATE PlannedAction = "update"
+	PLAN_DELETE PlannedAction = "delete"
+)
+
+/**
+ * Write which would have been made without dry-run, Diff lists the changed fields with secret data redacted
+ */
+type PlannedChange struct {
+	Action    PlannedAction
+	Kind      string
+	Namespace string
+	Name      string
+	Diff      []string
+}
+
+/**
+ * Collects the changes of a dry-run instead of writing them
+ */
+type ChangePlan struct {
+	mutex   sync.Mutex
+	changes []PlannedChange
+}
+
+func NewChangePlan() *ChangePlan {
+	return &ChangePlan{}
+}
+
+func (plan *ChangePlan) record(change PlannedChange) {
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+
+	plan.changes = append(plan.changes, change)
+}
+
+/**
+ * Returns all changes recorded so far and starts a new plan
+ */
+func (plan *ChangePlan) Drain() []PlannedChange {
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+
+	changes := plan.changes
+	plan.changes = nil
+	return changes
+}
+
+/**
+ * Prints the changes as plan, one block per object followed by a summary
+ */
+func WritePlan(writer io.Writer, changes []PlannedChange) error {
+	counts := map[PlannedAction]int{}
+
+	for _, change := range changes {
+		counts[change.Action]++
+
+		name := change.Name
+		if change.Namespace != "" {
+			name = change.Namespace + "/" + name
+		}
+		_, err := fmt.Fprintf(writer, "%s %s %s\n", change.Action, change.Kind, name)
+		if err != nil {
+			return err
+		}
+
+		for _, line := range change.Diff {
+			_, err = fmt.Fprintf(writer, "  %s\n", line)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := fmt.Fprintf(writer, "Plan: %d to create, %d to update, %d to delete\n", counts[PLAN_CREATE], counts[PLAN_UPDATE], counts[PLAN_DELETE])
+	return err
+}
+
+/**
+ * Lists the label, annotation and data changes between two secrets, data values are never printed.
+ * live is nil for secrets which do not exist yet.
+ */
+func secretDiff(live *v1.Secret, desired *v1.Secret) []string {
+	if live == nil {
+		live = &v1.Secret{}
+	}
+
+	liveData := map[string]string{}
+	for key, value := range live.Data {
+		liveData[key] = string(value)
+	}
+	desiredData := map[string]string{}
+	for key, value := range desired.Data {
+		desiredData[key] = string(value)
+	}
+
+	diff := stringMapDiff("label", live.Labels, desired.Labels, false)
+	diff = append(diff, stringMapDiff("annotation", live.Annotations, desired.Annotations, false)...)
+	diff = append(diff, stringMapDiff("data", liveData, desiredData, true)...)
+
+	return diff
+}
+
+func stringMapDiff(field string, live map[string]string, desired map[string]string, redact bool) []string {
+	keys := map[string]bool{}
+	for key := range live {
+		keys[key] = true
+	}
+	for key := range desired {
+		keys[key] = true
+	}
+
+	sorted := []string{}
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	diff := []string{}
+	for _, key := range sorted {
+		liveValue, inLive := live[key]
+		desiredValue, inDesired := desired[key]
+
+		switch {
+		case !inDesired:
+			diff = append(diff, fmt.Sprintf("- %s %s", field, key))
+		case !inLive && redact:
+			diff = append(diff, fmt.Sprintf("+ %s %s: <redacted>", field, key))
+		case !inLive:
+			diff = append(diff, fmt.Sprintf("+ %s %s: %q", field, key, desiredValue))
+		case liveValue == desiredValue:
+		case redact:
+			diff = append(diff, fmt.Sprintf("~ %s %s: <redacted>", field, key))
+		default:
+			diff = append(diff, fmt.Sprintf("~ %s %s: %q -> %q", field, key, liveValue, desiredValue))
+		}
+	}
+
+	return diff
+}
+
+/**
+ * Returns the keys below the path, which are owned by the field manager through server-side apply.
+ * Fields the same manager wrote via update or patch are not removed by an apply.
+ */
+func appliedKeys(secret *v1.Secret, manager string, path ...string) []string {
+	keys := []string{}
+
+	for _, entry := range secret.ManagedFields {
+		if entry.Manager != manager || entry.Operation != metav1.ManagedFieldsOperationApply || entry.FieldsV1 == nil {
+			continue
+		}
+
+		var fields map[string]interface{}
+		err := json.Unmarshal(entry.FieldsV1.Raw, &fields)
+		if err != nil {
+			continue
+		}
+
+		for _, step := range path {
+			fields, _ = fields["f:"+step].(map[string]interface{})
+		}
+
+		for field := range fields {
+			if len(field) > 2 && field[:2] == "f:" {
+				keys = append(keys, field[2:])
+			}
+		}
+	}
+
+	return keys
+}
+
+/**
+ * Tokens are not issued with -dry-run, so the placeholder is replaced by the token stored in the existing secret.
+ * Without an existing token the placeholder is kept, the data values are redacted in the plan anyway.
+ */
+func withLiveToken(live *v1.Secret, data map[string]string) map[string]string {
+	if live == nil {
+		return data
+	}
+
+	var config ArgoClusterConfig
+	err := json.Unmarshal(live.Data["config"], &config)
+	if err != nil || config.BearerToken == "" {
+		return data
+	}
+
+	replaced := map[string]string{}
+	for key, value := range data {
+		replaced[key] = strings.ReplaceAll(value, DRY_RUN_TOKEN_PLACEHOLDER, config.BearerToken)
+	}
+	return replaced
+}
+
+/**
+ * Reports if all fields of desired are set to the same values in live, like after a server-side apply of desired
+ */
+func containsFields(live interface{}, desired interface{}) bool {
+	return containsNormalizedFields(normalizeFields(live), normalizeFields(desired))
+}
+
+func containsNormalizedFields(live interface{}, desired interface{}) bool {
+	switch desiredValue := desired.(type) {
+	case map[string]interface{}:
+		liveValue, ok := live.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range desiredValue {
+			if !containsNormalizedFields(liveValue[key], value) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		liveValue, ok := live.([]interface{})
+		if !ok || len(liveValue) != len(desiredValue) {
+			return false
+		}
+		for i := range desiredValue {
+			if !containsNormalizedFields(liveValue[i], desiredValue[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(live, desired)
+	}
+}
+
+// Rendered objects hold other number types than the ones read from the API server
+func normalizeFields(value interface{}) interface{} {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+
+	var normalized interface{}
+	err = json.Unmarshal(encoded, &normalized)
+	if err != nil {
+		return value
+	}
+	return normalized
+}
+
+func (connector *ArgoConnector) planSecret(action PlannedAction, live *v1.Secret, desired *v1.Secret) {
+	change := PlannedChange{
+		Action:    action,
+		Kind:      "Secret",
+		Namespace: connector.namespace,
+	}
+
+	if desired != nil {
+		change.Name = desired.Name
+		change.Diff = secretDiff(live, desired)
+	} else {
+		change.Name = live.Name
+	}
+
+	connector.plan.record(change)
+}
+
+func (reconciler *AppProjectReconciler) planAppProject(action PlannedAction, name string) {
+	reconciler.plan.record(PlannedChange{
+		Action:    action,
+		Kind:      "AppProject",
+		Namespace: reconciler.namespace,
+		Name:      name,
+	})
+}
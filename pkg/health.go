@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+/**
+ * Tracks the state required for the liveness and readiness probes
+ */
+type health struct {
+	verified         atomic.Bool
+	standby          atomic.Bool
+	synced           atomic.Bool
+	lastSyncFinished atomic.Int64
+}
+
+func newHealth() *health {
+	h := &health{}
+	// Give the first sync the same time as any later one
+	h.lastSyncFinished.Store(time.Now().UnixMilli())
+	return h
+}
+
+func (h *health) becameLeader() {
+	// Start the liveness timeout for the first sync as leader
+	h.lastSyncFinished.Store(time.Now().UnixMilli())
+	h.standby.Store(false)
+}
+
+func (h *health) syncFinished(successful bool) {
+	h.lastSyncFinished.Store(time.Now().UnixMilli())
+	if successful {
+		h.synced.Store(true)
+	}
+}
+
+/**
+ * Liveness probe, fails if no full sync finished within -liveness-sync-intervals refresh intervals.
+ * Standby replicas of a leader election do not sync and are always alive.
+ */
+func (bridge *KKPArgoBridge) Healthz(w http.ResponseWriter, r *http.Request) {
+	if bridge.health.standby.Load() {
+		fmt.Fprintln(w, "ok")
+		return
+	}
+
+	maxAge := time.Duration(bridge.options.LivenessSyncIntervals) * bridge.options.RefreshInterval
+	age := time.Since(time.UnixMilli(bridge.health.lastSyncFinished.Load()))
+
+	if age > maxAge {
+		http.Error(w, fmt.Sprintf("no sync finished since %s", age.Round(time.Second)), http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
+
+/**
+ * Readiness probe, succeeds after KKP and ArgoCD got verified and the first full sync succeeded.
+ * Standby replicas are ready once verified, so rollouts are not blocked by them.
+ */
+func (bridge *KKPArgoBridge) Readyz(w http.ResponseWriter, r *http.Request) {
+	if !bridge.health.verified.Load() {
+		http.Error(w, "KKP and ArgoCD not verified yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	if bridge.health.standby.Load() {
+		fmt.Fprintln(w, "ok")
+		return
+	}
+
+	if !bridge.health.synced.Load() {
+		http.Error(w, "no successful sync yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,71 @@
+package pkg
+
+import (
+	"slices"
+)
+
+// Phase of KKP clusters whose control plane is up and not being changed
+const CLUSTER_PHASE_RUNNING string = "Running"
+
+// Phase of KKP clusters which are being deleted
+const CLUSTER_PHASE_TERMINATING string = "Terminating"
+
+// Value of a component inside status.extendedHealth, if it is healthy
+const HEALTH_STATUS_UP string = "HealthStatusUp"
+
+/**
+ * Health of a KKP cluster, as reported in its status
+ */
+type UserClusterHealth struct {
+	Phase          string
+	Paused         bool
+	Deleting       bool
+	ExtendedHealth map[string]string
+	// Whether the cluster passes the readiness gate, without -require-healthy-clusters true unless it is being deleted or failed to load
+	Ready bool
+}
+
+/**
+ * Decides whether clusters are ready to be registered in ArgoCD
+ */
+type ClusterHealthGate struct {
+	requiredHealth []string
+}
+
+func NewClusterHealthGate(requiredHealth []string) *ClusterHealthGate {
+	return &ClusterHealthGate{
+		requiredHealth: requiredHealth,
+	}
+}
+
+func newUserClusterHealth(clusterCRD *ClusterCRD, gate *ClusterHealthGate) UserClusterHealth {
+	extendedHealth := map[string]string{}
+	for component, status := range clusterCRD.Status.ExtendedHealth {
+		if value, ok := status.(string); ok {
+			extendedHealth[component] = value
+		}
+	}
+
+	health := UserClusterHealth{
+		Phase:          clusterCRD.Status.Phase,
+		Paused:         clusterCRD.Spec.Pause,
+		Deleting:       clusterCRD.DeletionTimestamp != nil || clusterCRD.Status.Phase == CLUSTER_PHASE_TERMINATING,
+		ExtendedHealth: extendedHealth,
+	}
+	health.Ready = !health.Deleting && (gate == nil || gate.ready(health))
+
+	return health
+}
+
+/**
+ * A cluster is ready if it is running, not paused and all required components are up
+ */
+func (gate *ClusterHealthGate) ready(health UserClusterHealth) bool {
+	if health.Deleting || health.Paused || health.Phase != CLUSTER_PHASE_RUNNING {
+		return false
+	}
+
+	return !slices.ContainsFunc(gate.requiredHealth, func(component string) bool {
+		return health.ExtendedHealth[component] != HEALTH_STATUS_UP
+	})
+}
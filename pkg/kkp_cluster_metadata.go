@@ -0,0 +1,101 @@
+package pkg
+
+import (
+	"time"
+)
+
+// Keys of the providers inside cloud and datacenter specs, which also contain other settings like operatingSystemProfiles
+var cloudProviders = []string{
+	"alibaba", "anexia", "aws", "azure", "baremetal", "bringyourown", "digitalocean", "edge", "fake", "gcp", "hetzner",
+	"kubevirt", "nutanix", "openstack", "packet", "vmwareclouddirector", "vsphere",
+}
+
+/**
+ * Typed metadata of a KKP cluster, so templates do not depend on the schema of RawData
+ */
+type UserClusterMetadata struct {
+	Version        string
+	Provider       string
+	Datacenter     string
+	Region         string
+	CNI            UserClusterCNI
+	ExposeStrategy string
+	OPA            bool
+	MLAMonitoring  bool
+	MLALogging     bool
+	Konnectivity   bool
+	OwnerEmail     string
+	Created        time.Time
+}
+
+type UserClusterCNI struct {
+	Type    string
+	Version string
+}
+
+func newUserClusterMetadata(clusterCRD *ClusterCRD, datacenters map[string]KKPDatacenter) UserClusterMetadata {
+	spec := clusterCRD.Spec
+
+	metadata := UserClusterMetadata{
+		Version:        spec.Version,
+		Provider:       cloudProvider(spec.Cloud),
+		ExposeStrategy: spec.ExposeStrategy,
+		OwnerEmail:     clusterCRD.Status.UserEmail,
+		Created:        clusterCRD.CreationTimestamp.Time,
+	}
+
+	metadata.Datacenter, _ = spec.Cloud["dc"].(string)
+	metadata.Region = datacenterRegion(datacenters[metadata.Datacenter].Spec, metadata.Provider)
+
+	if spec.CNIPlugin != nil {
+		metadata.CNI = UserClusterCNI{Type: spec.CNIPlugin.Type, Version: spec.CNIPlugin.Version}
+	}
+	if spec.OPAIntegration != nil {
+		metadata.OPA = spec.OPAIntegration.Enabled
+	}
+	if spec.MLA != nil {
+		metadata.MLAMonitoring = spec.MLA.MonitoringEnabled
+		metadata.MLALogging = spec.MLA.LoggingEnabled
+	}
+	if spec.ClusterNetwork.KonnectivityEnabled != nil {
+		metadata.Konnectivity = *spec.ClusterNetwork.KonnectivityEnabled
+	}
+
+	return metadata
+}
+
+/**
+ * Name of the provider, older KKP versions only set the spec of the provider without providerName.
+ * Datacenter specs have no providerName and are always resolved by their provider spec.
+ */
+func cloudProvider(cloud map[string]interface{}) string {
+	if providerName, ok := cloud["providerName"].(string); ok && providerName != "" {
+		return providerName
+	}
+
+	for _, provider := range cloudProviders {
+		if _, ok := cloud[provider].(map[string]interface{}); ok {
+			return provider
+		}
+	}
+
+	return ""
+}
+
+/**
+ * Region of the datacenter, which some providers call location
+ */
+func datacenterRegion(datacenterSpec map[string]interface{}, provider string) string {
+	providerSpec, ok := datacenterSpec[provider].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	for _, key := range []string{"region", "location"} {
+		if region, ok := providerSpec[key].(string); ok && region != "" {
+			return region
+		}
+	}
+
+	return ""
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
@@ -16,14 +17,23 @@ type KKPConnector struct {
 	seedSchema              schema.GroupVersionResource
 	projectSchema           schema.GroupVersionResource
 	fetchMachineDeployments bool
+	tokenIssuer             *UserClusterTokenIssuer
+	externalClusters        bool
+	filter                  *ClusterFilter
+	healthGate              *ClusterHealthGate
+	kubeconfigSecret        KubeconfigSecretReference
 }
 type KKPProject struct {
-	Name    string
-	ID      string
-	RawData map[string]interface{}
+	Name        string
+	ID          string
+	Labels      map[string]string
+	Annotations map[string]string
+	RawData     map[string]interface{}
+	// Only filled for AppProjects with -app-project-rbac
+	Bindings []KKPProjectBinding
 }
 
-func NewKKPConnector(dynamicClient *dynamic.DynamicClient, staticClient *kubernetes.Clientset, fetchMachineDeployments bool) *KKPConnector {
+func NewKKPConnector(dynamicClient *dynamic.DynamicClient, staticClient *kubernetes.Clientset, fetchMachineDeployments bool, tokenIssuer *UserClusterTokenIssuer, externalClusters bool, filter *ClusterFilter, healthGate *ClusterHealthGate, kubeconfigSecret KubeconfigSecretReference) *KKPConnector {
 
 	return &KKPConnector{
 		dynamicClient: *dynamicClient,
@@ -39,71 +49,108 @@ func NewKKPConnector(dynamicClient *dynamic.DynamicClient, staticClient *kuberne
 			Resource: "projects",
 		},
 		fetchMachineDeployments: fetchMachineDeployments,
+		tokenIssuer:             tokenIssuer,
+		externalClusters:        externalClusters,
+		filter:                  filter,
+		healthGate:              healthGate,
+		kubeconfigSecret:        kubeconfigSecret,
 	}
 }
 
-func (connector *KKPConnector) VerifyCRD() error {
-	_, err := connector.dynamicClient.Resource(connector.seedSchema).List(context.TODO(), metav1.ListOptions{})
+func (connector *KKPConnector) VerifyCRD(ctx context.Context) error {
+	_, err := connector.dynamicClient.Resource(connector.seedSchema).List(ctx, metav1.ListOptions{})
 	return err
 }
 
-func (connector *KKPConnector) GetSeeds() ([]KKPSeed, error) {
-	seedCrds, err := connector.dynamicClient.Resource(connector.seedSchema).List(context.TODO(), metav1.ListOptions{})
+/**
+ * Returns the seeds which could be loaded, and whether these are all seeds allowed by the filter
+ */
+func (connector *KKPConnector) GetSeeds(ctx context.Context) ([]KKPSeed, bool, error) {
+	seedCrds, err := connector.dynamicClient.Resource(connector.seedSchema).List(ctx, metav1.ListOptions{})
 
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
 	seeds := []KKPSeed{}
+	seedNames := []string{}
 
 	for _, seedConfig := range seedCrds.Items {
-		spec := seedConfig.Object["spec"].(map[string]interface{})
-		name := seedConfig.Object["metadata"].(map[string]interface{})["name"].(string)
-		kubeconfigSpec := spec["kubeconfig"].(map[string]interface{})
-		kubeconfigName := kubeconfigSpec["name"].(string)
-		kubeconfigNamespace := kubeconfigSpec["namespace"].(string)
-		var managementProxySettings map[string]interface{}
-		if spec["managementProxySettings"] != nil {
-			managementProxySettings = spec["managementProxySettings"].(map[string]interface{})
+		if !connector.filter.SeedAllowed(seedConfig.GetName()) {
+			continue
+		}
+		seedNames = append(seedNames, seedConfig.GetName())
+
+		seedCRD, err := ParseSeedCRD(seedConfig)
+		if err != nil {
+			log.Printf("Skipping invalid seed %s: %s\n", seedConfig.GetName(), err)
+			seedReachable.WithLabelValues(seedConfig.GetName()).Set(0)
+			continue
 		}
+		name := seedCRD.Name
 
-		kubeconfigSecret, err := connector.staticClient.CoreV1().Secrets(kubeconfigNamespace).Get(context.TODO(), kubeconfigName, metav1.GetOptions{})
+		kubeconfigSecret, err := connector.staticClient.CoreV1().Secrets(seedCRD.Spec.Kubeconfig.Namespace).Get(ctx, seedCRD.Spec.Kubeconfig.Name, metav1.GetOptions{})
 		if err != nil {
 			log.Printf("Failed to get kubeconfig for seed %s: %s\n", name, err)
+			seedReachable.WithLabelValues(name).Set(0)
 			continue
 		}
 
-		seed, err := NewSeed(name, kubeconfigSecret.Data["kubeconfig"], connector.fetchMachineDeployments, managementProxySettings)
+		seed, err := NewSeed(name, kubeconfigSecret.Data["kubeconfig"], connector.fetchMachineDeployments, seedCRD.Spec.ManagementProxySettings, connector.tokenIssuer, connector.filter, connector.healthGate, connector.kubeconfigSecret.WithSeedAnnotations(seedCRD.Annotations), newKKPSeedSpec(seedCRD.Spec))
 		if err != nil {
 			log.Printf("Failed to create seed %s: %s\n", name, err)
+			seedReachable.WithLabelValues(name).Set(0)
 			continue
 		}
 		seeds = append(seeds, *seed)
 	}
 
-	return seeds, nil
+	forgetRemovedSeedMetrics(seedNames)
+
+	return seeds, len(seeds) == len(seedNames), nil
 }
 
-func (connector *KKPConnector) GetProjects() ([]KKPProject, error) {
-	projectCrds, err := connector.dynamicClient.Resource(connector.projectSchema).List(context.TODO(), metav1.ListOptions{})
+/**
+ * Returns the projects allowed by the filter, and whether no project was skipped because it could not be parsed
+ */
+func (connector *KKPConnector) GetProjects(ctx context.Context) ([]KKPProject, bool, error) {
+	projectCrds, err := connector.dynamicClient.Resource(connector.projectSchema).List(ctx, connector.filter.projectListOptions())
 
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
 	projects := []KKPProject{}
+	allParsed := true
 
 	for _, projectCrd := range projectCrds.Items {
-		id := projectCrd.Object["metadata"].(map[string]interface{})["name"].(string)
-		name := projectCrd.Object["spec"].(map[string]interface{})["name"].(string)
-
-		projects = append(projects, KKPProject{
-			Name:    name,
-			ID:      id,
-			RawData: projectCrd.Object,
-		})
+		project, err := NewKKPProject(projectCrd)
+		if err != nil {
+			log.Printf("Skipping invalid project %s: %s\n", projectCrd.GetName(), err)
+			allParsed = false
+			continue
+		}
+		if !connector.filter.ProjectAllowed(*project) {
+			continue
+		}
+		projects = append(projects, *project)
 	}
 
-	return projects, nil
+	return projects, allParsed, nil
+
+}
+
+func NewKKPProject(projectCrd unstructured.Unstructured) (*KKPProject, error) {
+	project, err := ParseProjectCRD(projectCrd)
+	if err != nil {
+		return nil, err
+	}
 
+	return &KKPProject{
+		Name:        project.Spec.Name,
+		ID:          project.Name,
+		Labels:      project.Labels,
+		Annotations: project.Annotations,
+		RawData:     projectCrd.Object,
+	}, nil
 }
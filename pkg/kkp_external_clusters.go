@@ -0,0 +1,90 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Key of the kubeconfig inside the referenced secret, if the reference has none
+const EXTERNAL_CLUSTER_KUBECONFIG_KEY string = "kubeconfig"
+
+var externalClusterSchema = schema.GroupVersionResource{
+	Group:    "kubermatic.k8c.io",
+	Version:  "v1",
+	Resource: "externalclusters",
+}
+
+/**
+ * Returns the ExternalClusters imported into KKP, which live on the master instead of a seed
+ */
+func (connector *KKPConnector) GetExternalClusters(ctx context.Context, projects []KKPProject) ([]UserCluster, error) {
+	externalClusterCrds, err := connector.dynamicClient.Resource(externalClusterSchema).List(ctx, connector.filter.clusterListOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	clusters := []UserCluster{}
+
+	for _, externalCluster := range externalClusterCrds.Items {
+		if !connector.filter.ClusterAllowed(externalCluster.GetLabels()[PROJECT_ID_LABEL], projects) {
+			continue
+		}
+
+		userCluster, err := connector.GetExternalCluster(ctx, externalCluster)
+		if err != nil {
+			log.Printf("Failed to load ExternalCluster %s: %s\n", externalCluster.GetName(), err)
+			clusters = append(clusters, newFailedUserCluster(nil, externalCluster, true))
+			continue
+		}
+
+		clusters = append(clusters, *userCluster)
+	}
+
+	return clusters, nil
+}
+
+/**
+ * Builds a UserCluster without seed from an ExternalCluster by resolving its kubeconfig reference
+ */
+func (connector *KKPConnector) GetExternalCluster(ctx context.Context, externalCluster unstructured.Unstructured) (*UserCluster, error) {
+	clusterCRD, err := ParseExternalClusterCRD(externalCluster)
+	if err != nil {
+		return nil, err
+	}
+
+	reference := clusterCRD.Spec.KubeconfigReference
+	key := reference.Key
+	if key == "" {
+		key = EXTERNAL_CLUSTER_KUBECONFIG_KEY
+	}
+
+	kubeConfigSecret, err := connector.staticClient.CoreV1().Secrets(reference.Namespace).Get(ctx, reference.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ExternalCluster Kubeconfig %s/%s: %w", reference.Namespace, reference.Name, err)
+	}
+
+	kubeconfig, ok := kubeConfigSecret.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("ExternalCluster Kubeconfig %s/%s has no key %s", reference.Namespace, reference.Name, key)
+	}
+
+	return &UserCluster{
+		ID:          clusterCRD.Name,
+		Name:        clusterCRD.Spec.HumanReadableName,
+		ProjectID:   clusterCRD.Labels[PROJECT_ID_LABEL],
+		Labels:      clusterCRD.Labels,
+		Annotations: clusterCRD.Annotations,
+		External:    true,
+		Health: UserClusterHealth{
+			Deleting: clusterCRD.DeletionTimestamp != nil,
+			Ready:    clusterCRD.DeletionTimestamp == nil,
+		},
+		kubeconfig: kubeconfig,
+		RawData:    externalCluster.Object,
+	}, nil
+}
@@ -0,0 +1,130 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	userProjectBindingSchema = schema.GroupVersionResource{
+		Group:    "kubermatic.k8c.io",
+		Version:  "v1",
+		Resource: "userprojectbindings",
+	}
+	groupProjectBindingSchema = schema.GroupVersionResource{
+		Group:    "kubermatic.k8c.io",
+		Version:  "v1",
+		Resource: "groupprojectbindings",
+	}
+
+	// KKP project roles, ordered by their permissions
+	kkpProjectRoles = []string{"owners", "projectmanagers", "editors", "viewers"}
+)
+
+/**
+ * A user email or group with a KKP role inside a project
+ */
+type KKPProjectBinding struct {
+	Subject string
+	Role    string
+}
+
+/**
+ * Returns the bindings of UserProjectBindings and GroupProjectBindings by project ID
+ */
+func (connector *KKPConnector) GetProjectBindings(ctx context.Context) (map[string][]KKPProjectBinding, error) {
+	bindings := map[string][]KKPProjectBinding{}
+
+	userBindings, err := connector.dynamicClient.Resource(userProjectBindingSchema).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, obj := range userBindings.Items {
+		binding, err := ParseUserProjectBindingCRD(obj)
+		if err != nil {
+			log.Printf("Skipping invalid userprojectbinding %s: %s\n", obj.GetName(), err)
+			continue
+		}
+
+		projectID := binding.Spec.ProjectID
+		bindings[projectID] = append(bindings[projectID], KKPProjectBinding{
+			Subject: binding.Spec.UserEmail,
+			Role:    strings.TrimSuffix(binding.Spec.Group, "-"+projectID),
+		})
+	}
+
+	groupBindings, err := connector.dynamicClient.Resource(groupProjectBindingSchema).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, obj := range groupBindings.Items {
+		binding, err := ParseGroupProjectBindingCRD(obj)
+		if err != nil {
+			log.Printf("Skipping invalid groupprojectbinding %s: %s\n", obj.GetName(), err)
+			continue
+		}
+
+		projectID := binding.Spec.ProjectID
+		bindings[projectID] = append(bindings[projectID], KKPProjectBinding{
+			Subject: binding.Spec.Group,
+			Role:    binding.Spec.Role,
+		})
+	}
+
+	return bindings, nil
+}
+
+/**
+ * Builds one AppProject role per KKP role with at least one binding.
+ * Viewers can only read Applications, all other roles can manage them.
+ */
+func appProjectRBACRoles(appProjectName string, bindings []KKPProjectBinding) []interface{} {
+	subjectsByRole := map[string][]string{}
+	for _, binding := range bindings {
+		subjectsByRole[binding.Role] = append(subjectsByRole[binding.Role], binding.Subject)
+	}
+
+	roles := []interface{}{}
+
+	for _, kkpRole := range kkpProjectRoles {
+		subjects := subjectsByRole[kkpRole]
+		if len(subjects) == 0 {
+			continue
+		}
+		sort.Strings(subjects)
+
+		roleName := "kkp-" + kkpRole
+		subject := fmt.Sprintf("proj:%s:%s", appProjectName, roleName)
+		objects := appProjectName + "/*"
+
+		policies := []interface{}{
+			fmt.Sprintf("p, %s, applications, get, %s, allow", subject, objects),
+			fmt.Sprintf("p, %s, logs, get, %s, allow", subject, objects),
+		}
+		if kkpRole != "viewers" {
+			policies = append(policies, fmt.Sprintf("p, %s, applications, *, %s, allow", subject, objects))
+		}
+
+		groups := []interface{}{}
+		for _, s := range subjects {
+			groups = append(groups, s)
+		}
+
+		roles = append(roles, map[string]interface{}{
+			"name":        roleName,
+			"description": "Generated from the KKP " + kkpRole + " of the project",
+			"policies":    policies,
+			"groups":      groups,
+		})
+	}
+
+	return roles
+}
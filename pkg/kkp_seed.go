@@ -8,6 +8,7 @@ import (
 	"net/url"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
@@ -18,24 +19,56 @@ import (
 type KKPSeed struct {
 	Name                    string
 	KubeConfig              restclient.Config
+	rawKubeConfig           []byte // The seed kubeconfig as stored in its secret, to notice rotated credentials
 	dynamicClient           dynamic.DynamicClient
 	staticClient            kubernetes.Interface
 	clusterSchema           schema.GroupVersionResource
 	machineDeploymentSchema schema.GroupVersionResource
 	fetchMachineDeployments bool
 	ManagementProxy         map[string]interface{}
+	tokenIssuer             *UserClusterTokenIssuer
+	filter                  *ClusterFilter
+	healthGate              *ClusterHealthGate
+	kubeconfigSecret        KubeconfigSecretReference
+	Spec                    KKPSeedSpec
 }
 
 type UserCluster struct {
-	Seed               *KKPSeed
+	Seed               *KKPSeed // nil for ExternalClusters
 	ID                 string
 	Name               string
-	kubeconfig         []byte `json:"-"`
+	ProjectID          string
+	Labels             map[string]string
+	Annotations        map[string]string
+	External           bool // Imported into KKP as ExternalCluster instead of being managed by a seed
+	Health             UserClusterHealth
+	Metadata           UserClusterMetadata // Empty for ExternalClusters
+	Datacenter         KKPDatacenter       // Datacenter of the seed used by the cluster, empty for ExternalClusters
+	kubeconfig         []byte              `json:"-"`
 	RawData            map[string]interface{}
 	MachineDeployments []map[string]interface{}
 }
 
-func NewSeed(name string, kubeconfig []byte, fetchMachineDeployments bool, managementProxySettings map[string]interface{}) (*KKPSeed, error) {
+/**
+ * Placeholder for a cluster which failed to load, for example because its token could not be issued.
+ * It is not ready, so its existing secret and the AppProject of its project are kept instead of being cleaned up.
+ */
+func newFailedUserCluster(seed *KKPSeed, cluster unstructured.Unstructured, external bool) UserCluster {
+	return UserCluster{
+		Seed:        seed,
+		ID:          cluster.GetName(),
+		ProjectID:   cluster.GetLabels()[PROJECT_ID_LABEL],
+		Labels:      cluster.GetLabels(),
+		Annotations: cluster.GetAnnotations(),
+		External:    external,
+		Health: UserClusterHealth{
+			Deleting: cluster.GetDeletionTimestamp() != nil,
+		},
+		RawData: cluster.Object,
+	}
+}
+
+func NewSeed(name string, kubeconfig []byte, fetchMachineDeployments bool, managementProxySettings map[string]interface{}, tokenIssuer *UserClusterTokenIssuer, filter *ClusterFilter, healthGate *ClusterHealthGate, kubeconfigSecret KubeconfigSecretReference, spec KKPSeedSpec) (*KKPSeed, error) {
 	loadedKubeConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
 	if err != nil {
 		return nil, err
@@ -54,6 +87,7 @@ func NewSeed(name string, kubeconfig []byte, fetchMachineDeployments bool, manag
 	return &KKPSeed{
 		Name:                    name,
 		KubeConfig:              *loadedKubeConfig,
+		rawKubeConfig:           kubeconfig,
 		dynamicClient:           *dynamicClient,
 		staticClient:            staticClient,
 		fetchMachineDeployments: fetchMachineDeployments,
@@ -67,12 +101,20 @@ func NewSeed(name string, kubeconfig []byte, fetchMachineDeployments bool, manag
 			Version:  "v1alpha1",
 			Resource: "machinedeployments",
 		},
-		ManagementProxy: managementProxySettings,
+		ManagementProxy:  managementProxySettings,
+		tokenIssuer:      tokenIssuer,
+		filter:           filter,
+		healthGate:       healthGate,
+		kubeconfigSecret: kubeconfigSecret,
+		Spec:             spec,
 	}, nil
 }
 
-func (seed *KKPSeed) GetUserClusters() ([]UserCluster, error) {
-	clustersCrds, err := seed.dynamicClient.Resource(seed.clusterSchema).List(context.TODO(), metav1.ListOptions{})
+/**
+ * Loads all clusters of the seed which match the filter, projects are used to drop clusters of excluded projects
+ */
+func (seed *KKPSeed) GetUserClusters(ctx context.Context, projects []KKPProject) ([]UserCluster, error) {
+	clustersCrds, err := seed.dynamicClient.Resource(seed.clusterSchema).List(ctx, seed.filter.clusterListOptions())
 	if err != nil {
 		return nil, err
 	}
@@ -80,71 +122,109 @@ func (seed *KKPSeed) GetUserClusters() ([]UserCluster, error) {
 	clusters := []UserCluster{}
 
 	for _, cluster := range clustersCrds.Items {
-		id := cluster.Object["metadata"].(map[string]interface{})["name"].(string)
-		name := cluster.Object["spec"].(map[string]interface{})["humanReadableName"].(string)
-		nameSpace := "cluster-" + id
-
-		kubeConfigSecret, err := seed.staticClient.CoreV1().Secrets(nameSpace).Get(context.TODO(), "admin-kubeconfig", metav1.GetOptions{})
+		if !seed.filter.ClusterAllowed(cluster.GetLabels()[PROJECT_ID_LABEL], projects) {
+			continue
+		}
 
+		userCluster, err := seed.GetUserCluster(ctx, cluster)
 		if err != nil {
-			log.Printf("Failed to get UserCluster Kubeconfig %s.%s\n", nameSpace, kubeConfigSecret)
+			log.Printf("Failed to load UserCluster %s: %s\n", cluster.GetName(), err)
+			clusters = append(clusters, newFailedUserCluster(seed, cluster, false))
 			continue
 		}
 
-		var machineDeployments []map[string]interface{}
-		if seed.fetchMachineDeployments {
-			machineDeployments, err = seed.fetchMachineDeploymentsForUserCluster(kubeConfigSecret.Data["kubeconfig"])
-			if err != nil {
-				log.Printf("Failed to fetch MachineDeployments for UserCluster %s: %s\n", name, err)
-				continue
-			}
-		}
+		clusters = append(clusters, *userCluster)
+	}
 
-		clusters = append(clusters, UserCluster{
-			Seed:               seed,
-			ID:                 id,
-			Name:               name,
-			kubeconfig:         kubeConfigSecret.Data["kubeconfig"],
-			RawData:            cluster.Object,
-			MachineDeployments: machineDeployments,
-		})
+	// A deadline hit while loading the clusters would otherwise look like removed clusters
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
 	return clusters, nil
 }
 
-func (seed *KKPSeed) fetchMachineDeploymentsForUserCluster(kubeconfig []byte) ([]map[string]interface{}, error) {
-	loadedKubeConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+/**
+ * Builds a single UserCluster from its KKP Cluster object by fetching its kubeconfig secret, admin-kubeconfig by default.
+ * The secret is configured per seed, clusters may select another secret name allowed by the seed.
+ * With -user-cluster-tokens the admin credentials are replaced by a token of the bridge ServiceAccount.
+ * With -require-healthy-clusters clusters which are not ready are returned without kubeconfig.
+ */
+func (seed *KKPSeed) GetUserCluster(ctx context.Context, cluster unstructured.Unstructured) (*UserCluster, error) {
+	clusterCRD, err := ParseClusterCRD(cluster)
 	if err != nil {
 		return nil, err
 	}
 
-	if seed.ManagementProxy != nil {
-		proxyHost, _ := seed.ManagementProxy["proxyHost"].(string)
-		proxyProtocol, _ := seed.ManagementProxy["proxyProtocol"].(string)
-		if proxyHost != "" && proxyProtocol != "" {
+	id := clusterCRD.Name
+	name := clusterCRD.Spec.HumanReadableName
 
-			proxyURL := &url.URL{
-				Scheme: proxyProtocol,
-				Host:   proxyHost,
-			}
+	userCluster := &UserCluster{
+		Seed:        seed,
+		ID:          id,
+		Name:        name,
+		ProjectID:   clusterCRD.Labels[PROJECT_ID_LABEL],
+		Labels:      clusterCRD.Labels,
+		Annotations: clusterCRD.Annotations,
+		Health:      newUserClusterHealth(clusterCRD, seed.healthGate),
+		Metadata:    newUserClusterMetadata(clusterCRD, seed.Spec.Datacenters),
+		RawData:     cluster.Object,
+	}
+	userCluster.Datacenter = seed.Spec.Datacenters[userCluster.Metadata.Datacenter]
 
-			if seed.ManagementProxy["proxyPort"] != nil {
-				proxyURL = &url.URL{
-					Scheme: proxyProtocol,
-					Host:   fmt.Sprintf("%s:%d", proxyHost, seed.ManagementProxy["proxyPort"]),
-				}
-			}
-			loadedKubeConfig.Proxy = http.ProxyURL(proxyURL)
+	// The control plane of clusters which are not ready is possibly not reachable yet, or already gone
+	if !userCluster.Health.Ready {
+		return userCluster, nil
+	}
+
+	secretReference, err := seed.kubeconfigSecret.WithClusterAnnotations(clusterCRD.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	nameSpace := secretReference.namespaceFor(id)
+
+	kubeConfigSecret, err := seed.staticClient.CoreV1().Secrets(nameSpace).Get(ctx, secretReference.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get UserCluster Kubeconfig %s/%s: %w", nameSpace, secretReference.Name, err)
+	}
+
+	kubeconfig, ok := kubeConfigSecret.Data[secretReference.Key]
+	if !ok {
+		return nil, fmt.Errorf("UserCluster Kubeconfig %s/%s has no key %s", nameSpace, secretReference.Name, secretReference.Key)
+	}
+	if seed.tokenIssuer != nil {
+		kubeconfig, err = seed.tokenIssuer.ScopedKubeConfig(ctx, seed, id, kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to issue token for UserCluster %s: %w", name, err)
 		}
 	}
 
+	var machineDeployments []map[string]interface{}
+	if seed.fetchMachineDeployments {
+		machineDeployments, err = seed.fetchMachineDeploymentsForUserCluster(ctx, kubeConfigSecret.Data[secretReference.Key])
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch MachineDeployments for UserCluster %s: %w", name, err)
+		}
+	}
+
+	userCluster.kubeconfig = kubeconfig
+	userCluster.MachineDeployments = machineDeployments
+
+	return userCluster, nil
+}
+
+func (seed *KKPSeed) fetchMachineDeploymentsForUserCluster(ctx context.Context, kubeconfig []byte) ([]map[string]interface{}, error) {
+	loadedKubeConfig, err := seed.userClusterRESTConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
 	dynamicClient, err := dynamic.NewForConfig(loadedKubeConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	list, err := dynamicClient.Resource(seed.machineDeploymentSchema).Namespace("kube-system").List(context.TODO(), metav1.ListOptions{})
+	list, err := dynamicClient.Resource(seed.machineDeploymentSchema).Namespace("kube-system").List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return nil, err
 	}
@@ -157,3 +237,35 @@ func (seed *KKPSeed) fetchMachineDeploymentsForUserCluster(kubeconfig []byte) ([
 
 	return machineDeployments, nil
 }
+
+/**
+ * Loads the kubeconfig of a UserCluster, routed through the management proxy of the seed if one is configured
+ */
+func (seed *KKPSeed) userClusterRESTConfig(kubeconfig []byte) (*restclient.Config, error) {
+	loadedKubeConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if seed.ManagementProxy != nil {
+		proxyHost, _ := seed.ManagementProxy["proxyHost"].(string)
+		proxyProtocol, _ := seed.ManagementProxy["proxyProtocol"].(string)
+		if proxyHost != "" && proxyProtocol != "" {
+
+			proxyURL := &url.URL{
+				Scheme: proxyProtocol,
+				Host:   proxyHost,
+			}
+
+			if seed.ManagementProxy["proxyPort"] != nil {
+				proxyURL = &url.URL{
+					Scheme: proxyProtocol,
+					Host:   fmt.Sprintf("%s:%d", proxyHost, seed.ManagementProxy["proxyPort"]),
+				}
+			}
+			loadedKubeConfig.Proxy = http.ProxyURL(proxyURL)
+		}
+	}
+
+	return loadedKubeConfig, nil
+}
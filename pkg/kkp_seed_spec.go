@@ -0,0 +1,67 @@
+package pkg
+
+/**
+ * Parsed spec of a KKP seed, available to the templates
+ */
+type KKPSeedSpec struct {
+	Country           string
+	Location          string
+	Datacenters       map[string]KKPDatacenter
+	EtcdBackupRestore KKPEtcdBackupRestore
+}
+
+type KKPDatacenter struct {
+	Name     string
+	Country  string
+	Location string
+	Provider string
+	// region or location of the provider spec, empty if the provider has neither
+	Region string
+	// Raw provider specific settings of the datacenter
+	Spec map[string]interface{}
+}
+
+type KKPEtcdBackupRestore struct {
+	DefaultDestination string
+	Destinations       map[string]KKPBackupDestination
+}
+
+type KKPBackupDestination struct {
+	Endpoint   string
+	BucketName string
+}
+
+func newKKPSeedSpec(spec SeedCRDSpec) KKPSeedSpec {
+	seedSpec := KKPSeedSpec{
+		Country:     spec.Country,
+		Location:    spec.Location,
+		Datacenters: map[string]KKPDatacenter{},
+		EtcdBackupRestore: KKPEtcdBackupRestore{
+			Destinations: map[string]KKPBackupDestination{},
+		},
+	}
+
+	for name, datacenter := range spec.Datacenters {
+		provider := cloudProvider(datacenter.Spec)
+		seedSpec.Datacenters[name] = KKPDatacenter{
+			Name:     name,
+			Country:  datacenter.Country,
+			Location: datacenter.Location,
+			Provider: provider,
+			Region:   datacenterRegion(datacenter.Spec, provider),
+			Spec:     datacenter.Spec,
+		}
+	}
+
+	if spec.EtcdBackupRestore != nil {
+		seedSpec.EtcdBackupRestore.DefaultDestination = spec.EtcdBackupRestore.DefaultDestination
+		for name, destination := range spec.EtcdBackupRestore.Destinations {
+			seedSpec.EtcdBackupRestore.Destinations[name] = KKPBackupDestination{
+				Endpoint:   destination.Endpoint,
+				BucketName: destination.BucketName,
+			}
+		}
+	}
+
+	return seedSpec
+}
@@ -0,0 +1,263 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+/**
+ * Subset of the KKP Seed (kubermatic.k8c.io/v1) read by the bridge
+ */
+type SeedCRD struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              SeedCRDSpec `json:"spec"`
+}
+
+type SeedCRDSpec struct {
+	Kubeconfig              SeedKubeconfigReference      `json:"kubeconfig"`
+	ManagementProxySettings map[string]interface{}       `json:"managementProxySettings,omitempty"`
+	Datacenters             map[string]SeedDatacenterCRD `json:"datacenters,omitempty"`
+	Country                 string                       `json:"country,omitempty"`
+	Location                string                       `json:"location,omitempty"`
+	EtcdBackupRestore       *SeedEtcdBackupRestoreCRD    `json:"etcdBackupRestore,omitempty"`
+}
+
+type SeedDatacenterCRD struct {
+	Country  string `json:"country,omitempty"`
+	Location string `json:"location,omitempty"`
+	// Provider specific settings, like the region, keyed by the provider name
+	Spec map[string]interface{} `json:"spec,omitempty"`
+}
+
+type SeedEtcdBackupRestoreCRD struct {
+	Destinations       map[string]SeedBackupDestinationCRD `json:"destinations,omitempty"`
+	DefaultDestination string                              `json:"defaultDestination,omitempty"`
+}
+
+type SeedBackupDestinationCRD struct {
+	Endpoint   string `json:"endpoint,omitempty"`
+	BucketName string `json:"bucketName,omitempty"`
+}
+
+type SeedKubeconfigReference struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+/**
+ * Subset of the KKP Project (kubermatic.k8c.io/v1) read by the bridge
+ */
+type ProjectCRD struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              ProjectCRDSpec `json:"spec"`
+}
+
+type ProjectCRDSpec struct {
+	Name string `json:"name"`
+}
+
+/**
+ * Subset of the KKP Cluster (kubermatic.k8c.io/v1) read by the bridge
+ */
+type ClusterCRD struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              ClusterCRDSpec   `json:"spec"`
+	Status            ClusterCRDStatus `json:"status,omitempty"`
+}
+
+type ClusterCRDSpec struct {
+	HumanReadableName string                    `json:"humanReadableName"`
+	Pause             bool                      `json:"pause,omitempty"`
+	Version           string                    `json:"version,omitempty"`
+	Cloud             map[string]interface{}    `json:"cloud,omitempty"`
+	CNIPlugin         *ClusterCRDCNIPlugin      `json:"cniPlugin,omitempty"`
+	ExposeStrategy    string                    `json:"exposeStrategy,omitempty"`
+	OPAIntegration    *ClusterCRDOPAIntegration `json:"opaIntegration,omitempty"`
+	MLA               *ClusterCRDMLA            `json:"mla,omitempty"`
+	ClusterNetwork    ClusterCRDNetwork         `json:"clusterNetwork,omitempty"`
+}
+
+type ClusterCRDCNIPlugin struct {
+	Type    string `json:"type,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+type ClusterCRDOPAIntegration struct {
+	Enabled bool `json:"enabled,omitempty"`
+}
+
+type ClusterCRDMLA struct {
+	MonitoringEnabled bool `json:"monitoringEnabled,omitempty"`
+	LoggingEnabled    bool `json:"loggingEnabled,omitempty"`
+}
+
+type ClusterCRDNetwork struct {
+	KonnectivityEnabled *bool `json:"konnectivityEnabled,omitempty"`
+}
+
+type ClusterCRDStatus struct {
+	UserEmail string `json:"userEmail,omitempty"`
+	Phase     string `json:"phase,omitempty"`
+	// HealthStatusUp, HealthStatusDown or HealthStatusProvisioning per control plane component
+	ExtendedHealth map[string]interface{} `json:"extendedHealth,omitempty"`
+}
+
+/**
+ * Subset of the KKP ExternalCluster (kubermatic.k8c.io/v1) read by the bridge
+ */
+type ExternalClusterCRD struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              ExternalClusterCRDSpec `json:"spec"`
+}
+
+type ExternalClusterCRDSpec struct {
+	HumanReadableName   string                              `json:"humanReadableName"`
+	KubeconfigReference *ExternalClusterKubeconfigReference `json:"kubeconfigReference,omitempty"`
+}
+
+type ExternalClusterKubeconfigReference struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Key       string `json:"key,omitempty"`
+}
+
+/**
+ * Subset of the KKP UserProjectBinding (kubermatic.k8c.io/v1) read by the bridge
+ */
+type UserProjectBindingCRD struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              UserProjectBindingCRDSpec `json:"spec"`
+}
+
+type UserProjectBindingCRDSpec struct {
+	UserEmail string `json:"userEmail"`
+	ProjectID string `json:"projectID"`
+	// Role and project, like owners-<projectID>
+	Group string `json:"group"`
+}
+
+/**
+ * Subset of the KKP GroupProjectBinding (kubermatic.k8c.io/v1) read by the bridge
+ */
+type GroupProjectBindingCRD struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              GroupProjectBindingCRDSpec `json:"spec"`
+}
+
+type GroupProjectBindingCRDSpec struct {
+	Group     string `json:"group"`
+	ProjectID string `json:"projectID"`
+	Role      string `json:"role"`
+}
+
+// Label on KKP clusters, which references their project
+const PROJECT_ID_LABEL string = "project-id"
+
+func ParseSeedCRD(obj unstructured.Unstructured) (*SeedCRD, error) {
+	seed := &SeedCRD{}
+	err := fromUnstructured(obj, seed)
+	if err != nil {
+		return nil, err
+	}
+
+	if seed.Spec.Kubeconfig.Name == "" || seed.Spec.Kubeconfig.Namespace == "" {
+		return nil, fmt.Errorf("seed %s has no spec.kubeconfig.name and spec.kubeconfig.namespace", seed.Name)
+	}
+
+	return seed, nil
+}
+
+func ParseProjectCRD(obj unstructured.Unstructured) (*ProjectCRD, error) {
+	project := &ProjectCRD{}
+	err := fromUnstructured(obj, project)
+	if err != nil {
+		return nil, err
+	}
+
+	if project.Spec.Name == "" {
+		return nil, fmt.Errorf("project %s has no spec.name", project.Name)
+	}
+
+	return project, nil
+}
+
+func ParseClusterCRD(obj unstructured.Unstructured) (*ClusterCRD, error) {
+	cluster := &ClusterCRD{}
+	err := fromUnstructured(obj, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	if cluster.Spec.HumanReadableName == "" {
+		return nil, fmt.Errorf("cluster %s has no spec.humanReadableName", cluster.Name)
+	}
+
+	return cluster, nil
+}
+
+func ParseExternalClusterCRD(obj unstructured.Unstructured) (*ExternalClusterCRD, error) {
+	cluster := &ExternalClusterCRD{}
+	err := fromUnstructured(obj, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	if cluster.Spec.HumanReadableName == "" {
+		return nil, fmt.Errorf("externalcluster %s has no spec.humanReadableName", cluster.Name)
+	}
+
+	reference := cluster.Spec.KubeconfigReference
+	if reference == nil || reference.Name == "" || reference.Namespace == "" {
+		return nil, fmt.Errorf("externalcluster %s has no spec.kubeconfigReference.name and spec.kubeconfigReference.namespace", cluster.Name)
+	}
+
+	return cluster, nil
+}
+
+func ParseUserProjectBindingCRD(obj unstructured.Unstructured) (*UserProjectBindingCRD, error) {
+	binding := &UserProjectBindingCRD{}
+	err := fromUnstructured(obj, binding)
+	if err != nil {
+		return nil, err
+	}
+
+	if binding.Spec.UserEmail == "" || binding.Spec.ProjectID == "" || binding.Spec.Group == "" {
+		return nil, fmt.Errorf("userprojectbinding %s has no spec.userEmail, spec.projectID or spec.group", binding.Name)
+	}
+
+	return binding, nil
+}
+
+func ParseGroupProjectBindingCRD(obj unstructured.Unstructured) (*GroupProjectBindingCRD, error) {
+	binding := &GroupProjectBindingCRD{}
+	err := fromUnstructured(obj, binding)
+	if err != nil {
+		return nil, err
+	}
+
+	if binding.Spec.Group == "" || binding.Spec.ProjectID == "" || binding.Spec.Role == "" {
+		return nil, fmt.Errorf("groupprojectbinding %s has no spec.group, spec.projectID or spec.role", binding.Name)
+	}
+
+	return binding, nil
+}
+
+/**
+ * Converts the object into the typed target and ensures it is named
+ */
+func fromUnstructured(obj unstructured.Unstructured, target interface{ GetName() string }) error {
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, target)
+	if err != nil {
+		return fmt.Errorf("invalid %s %s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+
+	if target.GetName() == "" {
+		return errors.New("object without metadata.name")
+	}
+
+	return nil
+}
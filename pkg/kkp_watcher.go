@@ -0,0 +1,227 @@
+package pkg
+
+import (
+	"bytes"
+	"log"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Queue key which requests a full sync of all seeds, projects and clusters
+const FULL_SYNC_KEY string = "<full-sync>"
+
+/**
+ * Watches KKP seeds, projects, ExternalClusters and the clusters of every seed and feeds changes into the bridge workqueue
+ */
+type KKPWatcher struct {
+	queue       workqueue.TypedRateLimitingInterface[string]
+	factory     dynamicinformer.DynamicSharedInformerFactory
+	seeds       cache.SharedIndexInformer
+	projects    cache.SharedIndexInformer
+	seedWatches map[string]*seedWatch
+	lock        sync.Mutex
+	filter      *ClusterFilter
+}
+
+type seedWatch struct {
+	seed     KKPSeed
+	informer cache.SharedIndexInformer
+	stop     chan struct{}
+}
+
+func NewKKPWatcher(connector *KKPConnector, queue workqueue.TypedRateLimitingInterface[string]) (*KKPWatcher, error) {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(&connector.dynamicClient, 0)
+
+	watcher := &KKPWatcher{
+		queue:       queue,
+		factory:     factory,
+		seeds:       factory.ForResource(connector.seedSchema).Informer(),
+		projects:    factory.ForResource(connector.projectSchema).Informer(),
+		seedWatches: map[string]*seedWatch{},
+		filter:      connector.filter,
+	}
+
+	// Seeds and projects affect every cluster they contain, so any change to them results in a full sync.
+	// ExternalClusters are cheap to list from the master, so they are synced the same way.
+	fullSyncHandler := cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			if !isInInitialList {
+				queue.Add(FULL_SYNC_KEY)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			queue.Add(FULL_SYNC_KEY)
+		},
+		DeleteFunc: func(obj interface{}) {
+			queue.Add(FULL_SYNC_KEY)
+		},
+	}
+
+	informers := []cache.SharedIndexInformer{watcher.seeds, watcher.projects}
+	if connector.externalClusters {
+		informers = append(informers, factory.ForResource(externalClusterSchema).Informer())
+	}
+
+	for _, informer := range informers {
+		_, err := informer.AddEventHandler(fullSyncHandler)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return watcher, nil
+}
+
+func (watcher *KKPWatcher) Start(stop <-chan struct{}) {
+	watcher.factory.Start(stop)
+
+	for resource, synced := range watcher.factory.WaitForCacheSync(stop) {
+		if !synced {
+			log.Printf("Failed to sync informer cache for %s\n", resource.Resource)
+		}
+	}
+}
+
+/**
+ * Starts a cluster informer for every new seed and stops the informers of seeds which are gone.
+ * Informers of seeds whose kubeconfig changed, for example by rotated credentials, are restarted.
+ */
+func (watcher *KKPWatcher) WatchSeeds(seeds []KKPSeed) {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
+	current := map[string]bool{}
+
+	for _, seed := range seeds {
+		current[seed.Name] = true
+
+		existing, ok := watcher.seedWatches[seed.Name]
+		if ok && bytes.Equal(existing.seed.rawKubeConfig, seed.rawKubeConfig) {
+			existing.seed = seed
+			continue
+		}
+		if ok {
+			close(existing.stop)
+		}
+
+		log.Printf("Watching clusters of seed %s\n", seed.Name)
+		watcher.seedWatches[seed.Name] = watcher.watchSeed(seed)
+	}
+
+	for name, existing := range watcher.seedWatches {
+		if !current[name] {
+			log.Printf("Stopped watching clusters of seed %s\n", name)
+			close(existing.stop)
+			delete(watcher.seedWatches, name)
+		}
+	}
+}
+
+func (watcher *KKPWatcher) watchSeed(seed KKPSeed) *seedWatch {
+	// Clusters which stop matching the selector get deleted from the cache, which removes their secret
+	tweakListOptions := func(options *metav1.ListOptions) {
+		options.LabelSelector = watcher.filter.clusterSelector.String()
+	}
+	informer := dynamicinformer.NewFilteredDynamicInformer(&seed.dynamicClient, seed.clusterSchema, metav1.NamespaceAll, 0, cache.Indexers{}, tweakListOptions).Informer()
+
+	enqueue := func(obj interface{}) {
+		key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+		if err != nil {
+			log.Printf("Failed to build queue key for cluster on seed %s: %s\n", seed.Name, err)
+			return
+		}
+		watcher.queue.Add(key)
+	}
+
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		// The initial list is already covered by the full sync which started this watch
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			if !isInInitialList {
+				enqueue(obj)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			enqueue(newObj)
+		},
+		DeleteFunc: enqueue,
+	})
+	if err != nil {
+		log.Printf("Failed to register cluster event handler for seed %s: %s\n", seed.Name, err)
+	}
+
+	stop := make(chan struct{})
+	go informer.Run(stop)
+
+	return &seedWatch{
+		seed:     seed,
+		informer: informer,
+		stop:     stop,
+	}
+}
+
+/**
+ * Stops the cluster informers of all seeds
+ */
+func (watcher *KKPWatcher) Stop() {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
+	for name, existing := range watcher.seedWatches {
+		close(existing.stop)
+		delete(watcher.seedWatches, name)
+	}
+}
+
+/**
+ * Looks up a cluster in the informer caches of all watched seeds.
+ * synced is false if at least one seed cache is not synced yet, so a missing cluster could still exist.
+ */
+func (watcher *KKPWatcher) GetCluster(clusterID string) (seed *KKPSeed, cluster *unstructured.Unstructured, synced bool) {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
+	synced = true
+
+	for _, watch := range watcher.seedWatches {
+		if !watch.informer.HasSynced() {
+			synced = false
+			continue
+		}
+
+		obj, exists, err := watch.informer.GetStore().GetByKey(clusterID)
+		if err != nil || !exists {
+			continue
+		}
+
+		watchedSeed := watch.seed
+		return &watchedSeed, obj.(*unstructured.Unstructured), true
+	}
+
+	return nil, nil, synced
+}
+
+/**
+ * Returns all projects from the informer cache, which are allowed by the filter
+ */
+func (watcher *KKPWatcher) Projects() []KKPProject {
+	projects := []KKPProject{}
+
+	for _, obj := range watcher.projects.GetStore().List() {
+		project, err := NewKKPProject(*obj.(*unstructured.Unstructured))
+		if err != nil {
+			log.Printf("Skipping invalid project: %s\n", err)
+			continue
+		}
+		if !watcher.filter.ProjectAllowed(*project) {
+			continue
+		}
+		projects = append(projects, *project)
+	}
+
+	return projects
+}
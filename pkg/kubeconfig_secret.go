@@ -0,0 +1,81 @@
+package pkg
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	KUBECONFIG_SECRET_ANNOTATION           string = BASE_LABEL + "/kubeconfig-secret"
+	KUBECONFIG_SECRET_NAMESPACE_ANNOTATION        = BASE_LABEL + "/kubeconfig-secret-namespace"
+	KUBECONFIG_SECRET_KEY_ANNOTATION              = BASE_LABEL + "/kubeconfig-secret-key"
+	// Comma separated secret names on a seed, which clusters of the seed may select
+	KUBECONFIG_SECRET_ALLOWED_ANNOTATION = BASE_LABEL + "/kubeconfig-secret-allowed"
+	// Replaced with the cluster ID inside the namespace of a kubeconfig secret
+	CLUSTER_ID_PLACEHOLDER = "{id}"
+)
+
+/**
+ * Secret on the seed, which holds the kubeconfig stored for a UserCluster
+ */
+type KubeconfigSecretReference struct {
+	Name string
+	// May contain {id}, like the default cluster-{id}
+	Namespace string
+	Key       string
+	// Further secret names, which clusters may select by annotation
+	AllowedNames []string
+}
+
+/**
+ * The kubeconfig secret configured by the annotations of a seed, falling back to the fields of this reference
+ */
+func (reference KubeconfigSecretReference) WithSeedAnnotations(annotations map[string]string) KubeconfigSecretReference {
+	if value := annotations[KUBECONFIG_SECRET_ANNOTATION]; value != "" {
+		reference.Name = value
+	}
+	if value := annotations[KUBECONFIG_SECRET_NAMESPACE_ANNOTATION]; value != "" {
+		reference.Namespace = value
+	}
+	if value := annotations[KUBECONFIG_SECRET_KEY_ANNOTATION]; value != "" {
+		reference.Key = value
+	}
+
+	reference.AllowedNames = nil
+	for _, name := range strings.Split(annotations[KUBECONFIG_SECRET_ALLOWED_ANNOTATION], ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			reference.AllowedNames = append(reference.AllowedNames, name)
+		}
+	}
+
+	return reference
+}
+
+/**
+ * The kubeconfig secret configured by the annotations of a cluster.
+ * Clusters can be annotated by the members of their project, so the namespace is kept and only the names allowed by the seed can be selected,
+ * otherwise the credentials of other clusters could be copied into ArgoCD.
+ */
+func (reference KubeconfigSecretReference) WithClusterAnnotations(annotations map[string]string) (KubeconfigSecretReference, error) {
+	if _, ok := annotations[KUBECONFIG_SECRET_NAMESPACE_ANNOTATION]; ok {
+		return reference, fmt.Errorf("annotation %s is only supported on seeds", KUBECONFIG_SECRET_NAMESPACE_ANNOTATION)
+	}
+
+	if value := annotations[KUBECONFIG_SECRET_ANNOTATION]; value != "" && value != reference.Name {
+		if !slices.Contains(reference.AllowedNames, value) {
+			return reference, fmt.Errorf("kubeconfig secret %s is not allowed by annotation %s of the seed", value, KUBECONFIG_SECRET_ALLOWED_ANNOTATION)
+		}
+		reference.Name = value
+	}
+	if value := annotations[KUBECONFIG_SECRET_KEY_ANNOTATION]; value != "" {
+		reference.Key = value
+	}
+
+	return reference, nil
+}
+
+func (reference KubeconfigSecretReference) namespaceFor(clusterID string) string {
+	return strings.ReplaceAll(reference.Namespace, CLUSTER_ID_PLACEHOLDER, clusterID)
+}
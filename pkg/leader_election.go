@@ -0,0 +1,105 @@
+package pkg
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+/**
+ * Blocks until this replica holds the Lease inside the ArgoCD cluster and runs the bridge afterwards.
+ * The Lease gets released after the context is cancelled and the bridge finished its current writes.
+ * Only the leader writes to the ArgoCD namespace, standby replicas wait to take over.
+ */
+func (bridge *KKPArgoBridge) runWithLeaderElection(ctx context.Context, run func(ctx context.Context)) {
+	identity, err := os.Hostname()
+	if err != nil {
+		log.Fatal("Failed to get hostname for leader election: ", err)
+	}
+
+	namespace := bridge.options.LeaderElectionNamespace
+	if namespace == "" {
+		namespace = bridge.options.ArgoCDNamespace
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      bridge.options.LeaderElectionLeaseName,
+			Namespace: namespace,
+		},
+		Client: bridge.argoClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	bridge.health.standby.Store(true)
+
+	// RunOrDie releases the Lease as soon as its context is cancelled, without waiting for OnStartedLeading.
+	// The election therefore only ends once a running bridge returned, so a standby cannot take over mid-write.
+	electionCtx, cancelElection := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelElection()
+
+	var startLock sync.Mutex
+	var running sync.WaitGroup
+
+	go func() {
+		<-ctx.Done()
+		// Either the bridge was started before and gets awaited, or it will not start anymore
+		startLock.Lock()
+		startLock.Unlock()
+		running.Wait()
+		cancelElection()
+	}()
+
+	log.Printf("Waiting for leader election lease %s/%s as %s\n", namespace, bridge.options.LeaderElectionLeaseName, identity)
+
+	leaderelection.RunOrDie(electionCtx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   bridge.options.LeaseDuration,
+		RenewDeadline:   bridge.options.RenewDeadline,
+		RetryPeriod:     bridge.options.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            bridge.options.LeaderElectionLeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				startLock.Lock()
+				if ctx.Err() != nil {
+					startLock.Unlock()
+					return
+				}
+				running.Add(1)
+				startLock.Unlock()
+				defer running.Done()
+
+				log.Println("Acquired leader election lease")
+				bridge.health.becameLeader()
+
+				// Stops on shutdown as well as on a lost Lease
+				runCtx, cancel := context.WithCancel(leaderCtx)
+				defer cancel()
+				stop := context.AfterFunc(ctx, cancel)
+				defer stop()
+
+				run(runCtx)
+			},
+			OnStoppedLeading: func() {
+				if ctx.Err() != nil {
+					log.Println("Released leader election lease")
+					return
+				}
+				log.Fatal("Lost leader election lease")
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					log.Printf("Current leader is %s\n", leader)
+				}
+			},
+		},
+	})
+}
@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const METRICS_NAMESPACE string = "kubermatic_argocd_bridge"
+
+var (
+	syncDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "sync_duration_seconds",
+		Help:      "Duration of sync cycles, type is either full or cluster",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"type"})
+
+	seedFetchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "seed_fetch_duration_seconds",
+		Help:      "Duration of fetching the UserClusters of a seed",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"seed"})
+
+	seedReachable = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "seed_reachable",
+		Help:      "1 if the UserClusters of the seed could be fetched during the last sync, 0 otherwise",
+	}, []string{"seed"})
+
+	seedUserClusters = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "seed_user_clusters",
+		Help:      "Number of UserClusters fetched from the seed during the last sync",
+	}, []string{"seed"})
+
+	secretOperations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "secret_operations_total",
+		Help:      "ArgoCD cluster secrets written by the bridge, operation is created, updated or deleted",
+	}, []string{"operation"})
+
+	secretUpdatesSkipped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "secret_updates_skipped_total",
+		Help:      "Updates of ArgoCD cluster secrets skipped, because nothing changed",
+	})
+
+	templateRenderFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "template_render_failures_total",
+		Help:      "Failed renderings of the cluster secret template",
+	})
+
+	secretValidationFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "secret_validation_failures_total",
+		Help:      "Rendered cluster secrets rejected, because they do not match the ArgoCD cluster secret schema",
+	})
+
+	templateReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "template_reloads_total",
+		Help:      "Reloads of the cluster secret template files, result is either success or failure",
+	}, []string{"result"})
+
+	userClusterTokensIssued = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "user_cluster_tokens_issued_total",
+		Help:      "ServiceAccount tokens requested inside UserClusters with -user-cluster-tokens",
+	})
+
+	lastSuccessfulSync = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "last_successful_sync_timestamp_seconds",
+		Help:      "Unix timestamp of the last full sync without errors",
+	})
+
+	// Unix millis of the last successful sync, the process start until the first one succeeded
+	lastSuccessfulSyncMillis atomic.Int64
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "seconds_since_last_successful_sync",
+		Help:      "Seconds since the last full sync without errors, or since the start if none succeeded yet",
+	}, func() float64 {
+		return time.Since(time.UnixMilli(lastSuccessfulSyncMillis.Load())).Seconds()
+	})
+
+	// Seeds which currently have exported series, to drop the series of removed seeds
+	seedsWithMetrics = map[string]bool{}
+)
+
+func init() {
+	lastSuccessfulSyncMillis.Store(time.Now().UnixMilli())
+}
+
+func recordSuccessfulSync() {
+	now := time.Now()
+	lastSuccessfulSyncMillis.Store(now.UnixMilli())
+	lastSuccessfulSync.Set(float64(now.Unix()))
+}
+
+/**
+ * Removes the series of all seeds, which are not part of the provided seed names anymore
+ */
+func forgetRemovedSeedMetrics(seedNames []string) {
+	current := map[string]bool{}
+	for _, name := range seedNames {
+		current[name] = true
+	}
+
+	for name := range seedsWithMetrics {
+		if !current[name] {
+			seedReachable.DeleteLabelValues(name)
+			seedUserClusters.DeleteLabelValues(name)
+			seedFetchDuration.DeleteLabelValues(name)
+			delete(seedsWithMetrics, name)
+		}
+	}
+
+	for name := range current {
+		seedsWithMetrics[name] = true
+	}
+}
@@ -0,0 +1,48 @@
+package pkg
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+/**
+ * Builds a seed from its KKP Seed object, without connecting to it.
+ * Used to render templates offline, the seed has no clients.
+ */
+func NewOfflineSeed(seed unstructured.Unstructured) (*KKPSeed, error) {
+	seedCRD, err := ParseSeedCRD(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KKPSeed{
+		Name: seedCRD.Name,
+		Spec: newKKPSeedSpec(seedCRD.Spec),
+	}, nil
+}
+
+/**
+ * Builds a UserCluster from its KKP Cluster object and kubeconfig, without fetching anything from the seed.
+ * Used to render templates offline, the health gate of the bridge is not applied.
+ */
+func NewOfflineUserCluster(cluster unstructured.Unstructured, seed *KKPSeed, kubeconfig []byte) (*UserCluster, error) {
+	clusterCRD, err := ParseClusterCRD(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	userCluster := &UserCluster{
+		Seed:        seed,
+		ID:          clusterCRD.Name,
+		Name:        clusterCRD.Spec.HumanReadableName,
+		ProjectID:   clusterCRD.Labels[PROJECT_ID_LABEL],
+		Labels:      clusterCRD.Labels,
+		Annotations: clusterCRD.Annotations,
+		Health:      newUserClusterHealth(clusterCRD, nil),
+		Metadata:    newUserClusterMetadata(clusterCRD, seed.Spec.Datacenters),
+		kubeconfig:  kubeconfig,
+		RawData:     cluster.Object,
+	}
+	userCluster.Datacenter = seed.Spec.Datacenters[userCluster.Metadata.Datacenter]
+
+	return userCluster, nil
+}
@@ -0,0 +1,112 @@
+package pkg
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+/**
+ * Watches the cluster secret template files and reloads them on every change, followed by a full sync.
+ * The directories are watched, because ConfigMap volumes replace their files through symlinks instead of writing them.
+ */
+func (bridge *KKPArgoBridge) watchClusterSecretTemplates(ctx context.Context, argoConnector *ArgoConnector) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("Failed to watch cluster secret templates: %s\n", err)
+		return
+	}
+	defer watcher.Close()
+
+	watched := map[string]bool{}
+	watch := func(files []string) {
+		for _, file := range files {
+			directory := filepath.Dir(file)
+			if watched[directory] {
+				continue
+			}
+
+			err := watcher.Add(directory)
+			if err != nil {
+				log.Printf("Failed to watch cluster secret template %s: %s\n", file, err)
+				continue
+			}
+			watched[directory] = true
+		}
+	}
+
+	// The files could have changed before the watch started, for example while another replica was leading
+	watch(bridge.reloadClusterSecretTemplates(argoConnector))
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			// Templates referenced by the templates file can move into other directories
+			watch(bridge.reloadClusterSecretTemplates(argoConnector))
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Failed to watch cluster secret templates: %s\n", err)
+		}
+	}
+}
+
+/**
+ * Reads all template files again and hands them to the connector, returns the files which were read
+ */
+func (bridge *KKPArgoBridge) reloadClusterSecretTemplates(argoConnector *ArgoConnector) []string {
+	files := []string{}
+
+	clusterSecretTemplate := bridge.options.ClusterSecretTemplate
+	if path := bridge.options.ClusterSecretTemplatePath; path != "" {
+		files = append(files, path)
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			// Happens in between the symlink swaps of a ConfigMap update, the final swap triggers another reload
+			log.Printf("Failed to read cluster secret template %s, keeping the current one: %s\n", path, err)
+			return files
+		}
+		clusterSecretTemplate = string(data)
+	}
+
+	secretTemplateConfigs := bridge.options.ClusterSecretTemplates
+	if path := bridge.options.ClusterSecretTemplatesPath; path != "" {
+		configs, configFiles, err := LoadSecretTemplateConfigs(path)
+		files = append(files, configFiles...)
+		if err != nil {
+			log.Printf("Failed to read cluster secret templates %s, keeping the current ones: %s\n", path, err)
+			templateReloads.WithLabelValues("failure").Inc()
+			return files
+		}
+		secretTemplateConfigs = configs
+	}
+
+	changed, err := argoConnector.ReloadSecretTemplates(clusterSecretTemplate, secretTemplateConfigs)
+	if err != nil {
+		log.Printf("Rejected cluster secret templates, keeping the last good ones: %s\n", err)
+		templateReloads.WithLabelValues("failure").Inc()
+		return files
+	}
+	if !changed {
+		return files
+	}
+
+	log.Println("Reloaded cluster secret templates")
+	templateReloads.WithLabelValues("success").Inc()
+	bridge.queue.Add(FULL_SYNC_KEY)
+
+	return files
+}
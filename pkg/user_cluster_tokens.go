@@ -0,0 +1,229 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// Namespace of the ServiceAccount inside the UserClusters
+const USER_CLUSTER_TOKEN_NAMESPACE string = "kube-system"
+
+// Shortest expiration accepted by the TokenRequest API
+const MIN_USER_CLUSTER_TOKEN_EXPIRATION = 10 * time.Minute
+
+// Stands in for the tokens with -dry-run, plans use the token of the existing secret instead
+const DRY_RUN_TOKEN_PLACEHOLDER string = "kubermatic-argocd-bridge-dry-run-token"
+
+/**
+ * Issues bound ServiceAccount tokens inside the UserClusters, which replace the admin credentials in the ArgoCD secrets.
+ * Tokens are cached and only requested again once half of their lifetime passed, or the API server of the cluster changed.
+ */
+type UserClusterTokenIssuer struct {
+	serviceAccountName string
+	clusterRole        string
+	expiration         time.Duration
+	// Only replaces the credentials with DRY_RUN_TOKEN_PLACEHOLDER, without touching the UserClusters
+	dryRun bool
+
+	mutex  sync.Mutex
+	tokens map[string]userClusterToken
+}
+
+type userClusterToken struct {
+	host      string
+	token     string
+	issuedAt  time.Time
+	expiresAt time.Time
+}
+
+func NewUserClusterTokenIssuer(serviceAccountName string, clusterRole string, expiration time.Duration, dryRun bool) (*UserClusterTokenIssuer, error) {
+	// Falling back to cluster-admin would hand out the same permissions as the admin credentials
+	if clusterRole == "" {
+		return nil, errors.New("user cluster tokens require -user-cluster-token-cluster-role")
+	}
+	if expiration < MIN_USER_CLUSTER_TOKEN_EXPIRATION {
+		return nil, fmt.Errorf("user cluster token expiration must be at least %s", MIN_USER_CLUSTER_TOKEN_EXPIRATION)
+	}
+
+	return &UserClusterTokenIssuer{
+		serviceAccountName: serviceAccountName,
+		clusterRole:        clusterRole,
+		expiration:         expiration,
+		dryRun:             dryRun,
+		tokens:             map[string]userClusterToken{},
+	}, nil
+}
+
+/**
+ * Returns the admin kubeconfig of the UserCluster, with all credentials replaced by a token of the bridge ServiceAccount
+ */
+func (issuer *UserClusterTokenIssuer) ScopedKubeConfig(ctx context.Context, seed *KKPSeed, clusterID string, adminKubeConfig []byte) ([]byte, error) {
+	config, err := clientcmd.Load(adminKubeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if issuer.dryRun {
+		for name := range config.AuthInfos {
+			config.AuthInfos[name] = &clientcmdapi.AuthInfo{Token: DRY_RUN_TOKEN_PLACEHOLDER}
+		}
+		return clientcmd.Write(*config)
+	}
+
+	restConfig, err := seed.userClusterRESTConfig(adminKubeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := issuer.token(ctx, seed.Name+"/"+clusterID, restConfig.Host, func() (*kubernetes.Clientset, error) {
+		return kubernetes.NewForConfig(restConfig)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range config.AuthInfos {
+		config.AuthInfos[name] = &clientcmdapi.AuthInfo{Token: token}
+	}
+
+	return clientcmd.Write(*config)
+}
+
+func (issuer *UserClusterTokenIssuer) token(ctx context.Context, key string, host string, newClient func() (*kubernetes.Clientset, error)) (string, error) {
+	now := time.Now()
+
+	issuer.mutex.Lock()
+	for cachedKey, cached := range issuer.tokens {
+		if now.After(cached.expiresAt) {
+			delete(issuer.tokens, cachedKey)
+		}
+	}
+	cached, ok := issuer.tokens[key]
+	issuer.mutex.Unlock()
+
+	if ok && cached.host == host && now.Before(cached.issuedAt.Add(cached.expiresAt.Sub(cached.issuedAt)/2)) {
+		return cached.token, nil
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return "", err
+	}
+
+	err = issuer.ensureServiceAccount(ctx, client)
+	if err != nil {
+		return "", err
+	}
+
+	expirationSeconds := int64(issuer.expiration.Seconds())
+	tokenRequest, err := client.CoreV1().ServiceAccounts(USER_CLUSTER_TOKEN_NAMESPACE).CreateToken(ctx, issuer.serviceAccountName, &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			ExpirationSeconds: &expirationSeconds,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to request token for ServiceAccount %s/%s: %w", USER_CLUSTER_TOKEN_NAMESPACE, issuer.serviceAccountName, err)
+	}
+
+	log.Printf("Issued token for UserCluster %s, valid until %s\n", key, tokenRequest.Status.ExpirationTimestamp.Time.Format(time.RFC3339))
+	userClusterTokensIssued.Inc()
+
+	issuer.mutex.Lock()
+	issuer.tokens[key] = userClusterToken{
+		host:      host,
+		token:     tokenRequest.Status.Token,
+		issuedAt:  now,
+		expiresAt: tokenRequest.Status.ExpirationTimestamp.Time,
+	}
+	issuer.mutex.Unlock()
+
+	return tokenRequest.Status.Token, nil
+}
+
+/**
+ * Creates the ServiceAccount and binds it to the configured ClusterRole.
+ * A binding of the bridge to another role gets replaced, changed subjects of the binding are reset.
+ */
+func (issuer *UserClusterTokenIssuer) ensureServiceAccount(ctx context.Context, client *kubernetes.Clientset) error {
+	_, err := client.CoreV1().ServiceAccounts(USER_CLUSTER_TOKEN_NAMESPACE).Create(ctx, &v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   issuer.serviceAccountName,
+			Labels: map[string]string{MANAGED_LABEL: "true"},
+		},
+	}, metav1.CreateOptions{FieldManager: FIELD_MANAGER})
+	if err != nil && !apiErrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create ServiceAccount %s/%s: %w", USER_CLUSTER_TOKEN_NAMESPACE, issuer.serviceAccountName, err)
+	}
+
+	desired := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   issuer.serviceAccountName,
+			Labels: map[string]string{MANAGED_LABEL: "true"},
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     issuer.clusterRole,
+		},
+		Subjects: []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      issuer.serviceAccountName,
+			Namespace: USER_CLUSTER_TOKEN_NAMESPACE,
+		}},
+	}
+
+	bindings := client.RbacV1().ClusterRoleBindings()
+	existing, err := bindings.Get(ctx, desired.Name, metav1.GetOptions{})
+	if err != nil && !apiErrors.IsNotFound(err) {
+		return err
+	}
+
+	// Bindings of the same name created by someone else are neither changed nor replaced
+	if err == nil && existing.Labels[MANAGED_LABEL] != "true" {
+		return fmt.Errorf("ClusterRoleBinding %s exists without label %s=true and is not managed by the bridge", existing.Name, MANAGED_LABEL)
+	}
+
+	if err == nil && existing.RoleRef == desired.RoleRef {
+		if equality.Semantic.DeepEqual(existing.Subjects, desired.Subjects) {
+			return nil
+		}
+
+		log.Printf("Resetting subjects of ClusterRoleBinding %s\n", existing.Name)
+		existing.Subjects = desired.Subjects
+		_, err = bindings.Update(ctx, existing, metav1.UpdateOptions{FieldManager: FIELD_MANAGER})
+		if err != nil {
+			return fmt.Errorf("failed to update ClusterRoleBinding %s: %w", existing.Name, err)
+		}
+		return nil
+	}
+
+	if err == nil {
+		// The roleRef of a binding is immutable
+		log.Printf("Replacing ClusterRoleBinding %s bound to ClusterRole %s\n", existing.Name, existing.RoleRef.Name)
+		err = bindings.Delete(ctx, existing.Name, metav1.DeleteOptions{})
+		if err != nil && !apiErrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	_, err = bindings.Create(ctx, desired, metav1.CreateOptions{FieldManager: FIELD_MANAGER})
+	if err != nil {
+		return fmt.Errorf("failed to create ClusterRoleBinding %s: %w", desired.Name, err)
+	}
+
+	return nil
+}